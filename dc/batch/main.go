@@ -0,0 +1,66 @@
+// headless batch renderer: expressions file or random set -> png gallery + index.html
+//
+//	go run ./batch -exprs presets.txt -w 1024 -h 1024 -out gallery
+//	go run ./batch -random 50 -complexity 6 -out gallery
+//	go run ./batch -presets -w 16384 -h 16384 -ss 3 -out posters
+
+package main
+
+import (
+	"dc/dc"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+func main() {
+	exprsFile := flag.String("exprs", "", "file of expressions, one per line")
+	random := flag.Int("random", 0, "number of random expressions to generate")
+	complexity := flag.Int("complexity", 6, "complexity of random expressions")
+	presets := flag.Bool("presets", false, "render the built-in presets")
+	w := flag.Int("w", 1024, "image width")
+	h := flag.Int("h", 1024, "image height")
+	ss := flag.Int("ss", 1, "NxN supersampling, >1 renders in tiles streamed to png")
+	thumbW := flag.Int("thumb", 256, "thumbnail width, 0 uses full image")
+	outDir := flag.String("out", "gallery", "output directory")
+	flag.Parse()
+
+	var exprs []string
+	if *exprsFile != "" {
+		fexprs, err := dc.ReadExpressions(*exprsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exprs = append(exprs, fexprs...)
+	}
+	if *presets {
+		exprs = append(exprs, dc.Presets...)
+	}
+	if *random > 0 {
+		exprs = append(exprs, dc.RandomExpressions(*random, *complexity)...)
+	}
+	if len(exprs) == 0 {
+		flag.Usage()
+		log.Fatal("no expressions: use -exprs, -presets or -random")
+	}
+
+	items, err := dc.RenderBatch(exprs, *w, *h, *ss, *thumbW, *outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, item := range items {
+		if item.Err != "" {
+			fmt.Printf("%03d error: %s, %s\n", i, item.Err, item.Expression)
+		} else {
+			fmt.Printf("%03d %5.0f ms, %s\n", i, item.Lap, item.Expression)
+		}
+	}
+
+	index := filepath.Join(*outDir, "index.html")
+	title := fmt.Sprintf("Domain Coloring %v x %v, %v images", *w, *h, len(items))
+	if err := dc.WriteHtmlIndex(items, title, *thumbW, index); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("index:", index)
+}
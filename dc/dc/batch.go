@@ -0,0 +1,142 @@
+// headless batch rendering of expressions to png galleries
+
+package dc
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type BatchItem struct {
+	Expression string
+	File       string
+	Thumb      string
+	Lap        float64
+	Err        string
+}
+
+// read expressions from a text file, one per line, skip blanks & # comments
+func ReadExpressions(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	var exprs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			exprs = append(exprs, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return exprs, nil
+}
+
+// n random expressions of given complexity that compile ok
+func RandomExpressions(n, complexity int) []string {
+	exprs := make([]string, 0, n)
+	for len(exprs) < n {
+		expr := GenRandom(complexity)
+		if zc := NewCompiler(expr); zc.Ok() {
+			exprs = append(exprs, expr)
+		}
+	}
+	return exprs
+}
+
+// render each expression at w x h through TiledRender to outDir/dc###.png,
+// plus a thumbnail of thumbW width (0 -> no thumbnail).
+// supersample > 1 antialiases with NxN samples per pixel
+func RenderBatch(exprs []string, w, h, supersample, thumbW int, outDir string) ([]BatchItem, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", outDir, err)
+	}
+
+	items := make([]BatchItem, 0, len(exprs))
+	for i, expr := range exprs {
+		item := BatchItem{Expression: expr}
+
+		if zc := NewCompiler(expr); !zc.Ok() {
+			item.Err = zc.err_message
+			items = append(items, item)
+			continue
+		}
+
+		item.File = fmt.Sprintf("dc%03d.png", i)
+		// tiled & streamed, never the whole w*h image in memory
+		tr := NewTiledRender(w, h, supersample, expr)
+		if err := tr.WritePng(filepath.Join(outDir, item.File)); err != nil {
+			return items, err
+		}
+		item.Lap = tr.Lap
+
+		if thumbW > 0 {
+			thumb := NewDC(thumbW, thumbW*h/w, expr)
+			thumb.GenImageMt()
+			item.Thumb = fmt.Sprintf("dc%03d_thumb.png", i)
+			if err := thumb.WritePng(filepath.Join(outDir, item.Thumb)); err != nil {
+				return items, err
+			}
+		} else {
+			item.Thumb = item.File
+		}
+
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #ddd; }
+.item { display: inline-block; width: {{.ThumbW}}px; margin: 8px; vertical-align: top; }
+.item img { width: {{.ThumbW}}px; }
+.expr { font-family: monospace; word-wrap: break-word; }
+.err { color: #f66; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Items}}<div class="item">
+{{if .Err}}<div class="err">{{.Err}}</div>{{else}}<a href="{{.File}}"><img src="{{.Thumb}}" alt="{{.Expression}}"></a>
+<div>{{printf "%.0f" .Lap}} ms</div>{{end}}
+<div class="expr">{{.Expression}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// write an html index of rendered items with expression, lap & thumbnail
+func WriteHtmlIndex(items []BatchItem, title string, thumbW int, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if thumbW <= 0 {
+		thumbW = 256
+	}
+	err = indexTemplate.Execute(file, struct {
+		Title  string
+		ThumbW int
+		Items  []BatchItem
+	}{title, thumbW, items})
+	if err != nil {
+		return fmt.Errorf("failed to write index %s: %w", filename, err)
+	}
+	return nil
+}
@@ -14,7 +14,8 @@ import (
 )
 
 const (
-	pi2 = math.Pi * 2
+	pi2         = math.Pi * 2
+	randomTries = 50 // max expressions tried by Random to pass DefaultQuality
 )
 
 var Presets = []string{
@@ -61,27 +62,24 @@ func NewDC(w int, h int, expression string) DC {
 }
 
 func (dc_ *DC) genPixel(th, index_ int) {
-	pow3 := func(x float64) float64 { return x * x * x }
-
-	limit := math.Pi
-
-	rmi, rma, imi, ima := -limit, limit, -limit, limit
-
 	x, y := math.Mod(float64(index_), float64(dc_.w)), float64(index_)/float64(dc_.w)
 
 	// map pixel to complex plane
-	z := complex(float64(rmi+(rma-rmi)*x/float64(dc_.w)), float64(imi+(ima-imi)*y/float64(dc_.h)))
+	z := mapToPlane(x, y, dc_.w, dc_.h)
 
-	// execute
-	var result complex128
-	result = dc_.z_comp.execute(z)
+	// execute & convert result to color
+	dc_.image[index_] = domainColor(dc_.z_comp.execute(z))
+}
+
+// argb color of a complex value: hue from phase, sat/val from modulus e-bands
+func domainColor(result complex128) uint32 {
+	pow3 := func(x float64) float64 { return x * x * x }
 
-	// convert result to color
 	hue, m := cmplx.Phase(result), cmplx.Abs(result)
 	hue = math.Mod(math.Mod(hue, pi2)+pi2, pi2) / pi2
 
 	ranges, rangee := 0.0, 1.0
-	for m > rangee {
+	for m > rangee && !math.IsInf(m, 1) {
 		ranges = rangee
 		rangee *= math.E
 	}
@@ -97,7 +95,16 @@ func (dc_ *DC) genPixel(th, index_ int) {
 	sat := 0.4 + (1-pow3(1-kk))*0.6
 	val := 0.6 + (1-pow3(1-(1-kk)))*0.4
 
-	dc_.image[index_] = hsv_2_rgb(hue, sat, val)
+	return hsv_2_rgb(hue, sat, val)
+}
+
+// pixel x,y in w x h to the [-pi,pi] x [-pi,pi] domain
+func mapToPlane(x, y float64, w, h int) complex128 {
+	limit := math.Pi
+
+	rmi, rma, imi, ima := -limit, limit, -limit, limit
+
+	return complex(rmi+(rma-rmi)*x/float64(w), imi+(ima-imi)*y/float64(h))
 }
 
 func hsv_2_rgb(h float64, s float64, v float64) uint32 {
@@ -216,7 +223,8 @@ func (dc_ *DC) WritePng(filename string) error {
 
 func (dc_ *DC) Random(complexity int) {
 	// dc_.z_comp = GenRandomExpression(complexity) // old school way
-	dc_.z_comp = NewCompiler(GenRandom(complexity))
+	expr, _ := GenRandomQuality(complexity, DefaultQuality, randomTries)
+	dc_.z_comp = NewCompiler(expr)
 	dc_.GenImageMt()
 }
 
@@ -0,0 +1,150 @@
+// 3d views of domain coloring: riemann sphere and |f(z)| surface, exported as obj/ply
+
+package dc
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+)
+
+const maxSurfaceHeight = 4.0 // log(1+|f|) clamp for poles
+
+type Vertex struct {
+	X, Y, Z float64
+	R, G, B float64 // 0..1
+}
+
+type Mesh struct {
+	Name     string
+	Vertexes []Vertex
+	Faces    [][4]int // quads, vertex indexes
+}
+
+func vertexColor(c uint32) (float64, float64, float64) {
+	return float64((c>>16)&0xff) / 255, float64((c>>8)&0xff) / 255, float64(c&0xff) / 255
+}
+
+// res x res grid of quads
+func (m *Mesh) gridFaces(resU, resV int, wrapU bool) {
+	nu := resU - 1
+	if wrapU {
+		nu = resU
+	}
+	for v := 0; v < resV-1; v++ {
+		for u := range nu {
+			u1 := (u + 1) % resU
+			m.Faces = append(m.Faces, [4]int{v*resU + u, v*resU + u1, (v+1)*resU + u1, (v+1)*resU + u})
+		}
+	}
+}
+
+// unit sphere colored by f(z), z the stereographic projection from the north pole
+func NewSphereMesh(expression string, res int) (Mesh, error) {
+	if res < 2 {
+		return Mesh{}, fmt.Errorf("mesh resolution %d, needs at least 2", res)
+	}
+	zc := NewCompiler(expression)
+	if !zc.Ok() {
+		return Mesh{}, fmt.Errorf("expression error: %s", zc.err_message)
+	}
+
+	m := Mesh{Name: expression, Vertexes: make([]Vertex, 0, res*res)}
+
+	for iv := range res {
+		// avoid the poles, north pole maps to infinity
+		theta := math.Pi * (float64(iv) + 0.5) / float64(res)
+		for iu := range res {
+			phi := pi2 * float64(iu) / float64(res)
+
+			x, y, z := math.Sin(theta)*math.Cos(phi), math.Sin(theta)*math.Sin(phi), math.Cos(theta)
+			w := complex(x/(1-z), y/(1-z))
+
+			r, g, b := vertexColor(domainColor(zc.execute(w)))
+			m.Vertexes = append(m.Vertexes, Vertex{x, y, z, r, g, b})
+		}
+	}
+	m.gridFaces(res, res, true)
+
+	return m, nil
+}
+
+// surface height log(1+|f(z)|) over the [-pi,pi]^2 dc domain, colored by f(z)
+func NewSurfaceMesh(expression string, res int, heightScale float64) (Mesh, error) {
+	if res < 2 {
+		return Mesh{}, fmt.Errorf("mesh resolution %d, needs at least 2", res)
+	}
+	zc := NewCompiler(expression)
+	if !zc.Ok() {
+		return Mesh{}, fmt.Errorf("expression error: %s", zc.err_message)
+	}
+
+	m := Mesh{Name: expression, Vertexes: make([]Vertex, 0, res*res)}
+
+	for iy := range res {
+		for ix := range res {
+			z := mapToPlane(float64(ix), float64(iy), res-1, res-1)
+			result := zc.execute(z)
+
+			height := math.Log1p(cmplx.Abs(result))
+			if math.IsNaN(height) || height > maxSurfaceHeight {
+				height = maxSurfaceHeight
+			}
+
+			r, g, b := vertexColor(domainColor(result))
+			m.Vertexes = append(m.Vertexes, Vertex{real(z) / math.Pi, imag(z) / math.Pi, height * heightScale, r, g, b})
+		}
+	}
+	m.gridFaces(res, res, false)
+
+	return m, nil
+}
+
+func (m *Mesh) WriteObj(fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", fileName, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintln(writer, "# Domain Coloring:", m.Name)
+	for _, v := range m.Vertexes {
+		fmt.Fprintf(writer, "v %.4f %.4f %.4f %.3f %.3f %.3f\n", v.X, v.Y, v.Z, v.R, v.G, v.B)
+	}
+	for _, face := range m.Faces {
+		fmt.Fprintln(writer, "f", face[0]+1, face[1]+1, face[2]+1, face[3]+1)
+	}
+	return writer.Flush()
+}
+
+// ascii ply with uchar vertex colors
+func (m *Mesh) WritePly(fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", fileName, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintln(writer, "ply")
+	fmt.Fprintln(writer, "format ascii 1.0")
+	fmt.Fprintln(writer, "comment Domain Coloring:", m.Name)
+	fmt.Fprintln(writer, "element vertex", len(m.Vertexes))
+	fmt.Fprintln(writer, "property float x\nproperty float y\nproperty float z")
+	fmt.Fprintln(writer, "property uchar red\nproperty uchar green\nproperty uchar blue")
+	fmt.Fprintln(writer, "element face", len(m.Faces))
+	fmt.Fprintln(writer, "property list uchar int vertex_indices")
+	fmt.Fprintln(writer, "end_header")
+
+	toByte := func(c float64) int { return int(math.Round(c * 255)) }
+	for _, v := range m.Vertexes {
+		fmt.Fprintf(writer, "%.4f %.4f %.4f %d %d %d\n", v.X, v.Y, v.Z, toByte(v.R), toByte(v.G), toByte(v.B))
+	}
+	for _, face := range m.Faces {
+		fmt.Fprintln(writer, 4, face[0], face[1], face[2], face[3])
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,151 @@
+// image statistics to filter out boring random expressions
+
+package dc
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+const (
+	statsRes  = 64 // sample grid resolution used for scoring
+	hueBins   = 32
+	edgePhase = math.Pi / 4 // phase jump between neighbours considered an edge
+)
+
+type ImageStats struct {
+	HueEntropy  float64 // normalized hue histogram entropy 0..1
+	NanFraction float64 // fraction of NaN/Inf results 0..1
+	EdgeDensity float64 // fraction of neighbour pairs with a phase/modulus jump 0..1
+}
+
+type Quality struct {
+	MinHueEntropy  float64
+	MaxNanFraction float64
+	MinEdgeDensity float64
+	MaxEdgeDensity float64 // above this is mostly noise
+}
+
+var DefaultQuality = Quality{
+	MinHueEntropy:  0.6,
+	MaxNanFraction: 0.05,
+	MinEdgeDensity: 0.05,
+	MaxEdgeDensity: 0.6,
+}
+
+// evaluate zc on a statsRes x statsRes grid covering the dc domain and score it
+func (zc *ZCompiler) Stats() ImageStats {
+	var stats ImageStats
+	if !zc.Ok() {
+		stats.NanFraction = 1
+		return stats
+	}
+
+	isBad := func(c complex128) bool { return cmplx.IsNaN(c) || cmplx.IsInf(c) }
+	band := func(m float64) int { // e-based modulus band as used in the coloring
+		if m <= 1 {
+			return 0
+		}
+		return int(math.Log(m)) + 1
+	}
+
+	vals := make([]complex128, statsRes*statsRes)
+	hist := make([]int, hueBins)
+	nbad, ngood := 0, 0
+
+	for index := range vals {
+		x, y := float64(index%statsRes), float64(index/statsRes)
+		vals[index] = zc.execute(mapToPlane(x, y, statsRes, statsRes))
+
+		if isBad(vals[index]) {
+			nbad++
+			continue
+		}
+		hue := math.Mod(math.Mod(cmplx.Phase(vals[index]), pi2)+pi2, pi2) / pi2
+		hist[min(int(hue*hueBins), hueBins-1)]++
+		ngood++
+	}
+
+	stats.NanFraction = float64(nbad) / float64(len(vals))
+
+	if ngood > 0 {
+		entropy := 0.0
+		for _, n := range hist {
+			if n > 0 {
+				p := float64(n) / float64(ngood)
+				entropy -= p * math.Log(p)
+			}
+		}
+		stats.HueEntropy = entropy / math.Log(hueBins)
+	}
+
+	edge := func(a, b complex128) bool {
+		if isBad(a) || isBad(b) {
+			return false
+		}
+		dphase := math.Abs(cmplx.Phase(a) - cmplx.Phase(b))
+		dphase = math.Min(dphase, pi2-dphase)
+		return dphase > edgePhase || band(cmplx.Abs(a)) != band(cmplx.Abs(b))
+	}
+
+	nedges, npairs := 0, 0
+	for y := range statsRes {
+		for x := range statsRes {
+			c := vals[y*statsRes+x]
+			if x+1 < statsRes {
+				if edge(c, vals[y*statsRes+x+1]) {
+					nedges++
+				}
+				npairs++
+			}
+			if y+1 < statsRes {
+				if edge(c, vals[(y+1)*statsRes+x]) {
+					nedges++
+				}
+				npairs++
+			}
+		}
+	}
+	stats.EdgeDensity = float64(nedges) / float64(npairs)
+
+	return stats
+}
+
+func (q Quality) Accept(stats ImageStats) bool {
+	return stats.HueEntropy >= q.MinHueEntropy &&
+		stats.NanFraction <= q.MaxNanFraction &&
+		stats.EdgeDensity >= q.MinEdgeDensity &&
+		stats.EdgeDensity <= q.MaxEdgeDensity
+}
+
+// single figure of merit used to keep the best candidate when none is accepted
+func (stats ImageStats) Score() float64 {
+	edges := stats.EdgeDensity
+	if edges > DefaultQuality.MaxEdgeDensity {
+		edges = 2*DefaultQuality.MaxEdgeDensity - edges
+	}
+	return stats.HueEntropy * (1 - stats.NanFraction) * math.Sqrt(math.Max(edges, 0))
+}
+
+// GenRandom retrying up to maxTries until the expression passes q,
+// returns the best scored one otherwise
+func GenRandomQuality(complexity int, q Quality, maxTries int) (string, ImageStats) {
+	bestExpr, bestStats, bestScore := "z", ImageStats{}, -1.0
+
+	for range max(maxTries, 1) {
+		expr := GenRandom(complexity)
+		zc := NewCompiler(expr)
+		if !zc.Ok() {
+			continue
+		}
+
+		stats := zc.Stats()
+		if q.Accept(stats) {
+			return expr, stats
+		}
+		if score := stats.Score(); score > bestScore {
+			bestExpr, bestStats, bestScore = expr, stats, score
+		}
+	}
+	return bestExpr, bestStats
+}
@@ -73,4 +73,28 @@ func Test_GenRandom() {
 			fmt.Printf("%02d: %s\n", i, expr)
 		}
 	}
-}
\ No newline at end of file
+}
+func Test_quality() {
+	for i := range 10 {
+		expr, stats := GenRandomQuality(4, DefaultQuality, 50)
+		fmt.Printf("%02d: entropy %.2f, nan %.2f, edges %.2f, %s\n", i, stats.HueEntropy, stats.NanFraction, stats.EdgeDensity, expr)
+	}
+}
+func Test_tiled() {
+	tr := NewTiledRender(1024*4, 1024*4, 3, Presets[1])
+	if err := tr.WritePng("tiled.png"); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("tiled %v x %v ss %v: %4.0f ms\n", tr.W, tr.H, tr.Supersample, tr.Lap)
+}
+func Test_mesh() {
+	expr := Presets[6]
+	if sphere, err := NewSphereMesh(expr, 256); err == nil {
+		sphere.WriteObj("sphere.obj")
+		sphere.WritePly("sphere.ply")
+	}
+	if surface, err := NewSurfaceMesh(expr, 256, 0.5); err == nil {
+		surface.WriteObj("surface.obj")
+		surface.WritePly("surface.ply")
+	}
+}
@@ -0,0 +1,183 @@
+// tiled high resolution rendering streamed to png, with optional supersampling
+
+package dc
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const DefaultTileH = 64 // rows per tile strip, a strip holds w*TileH pixels
+
+type TiledRender struct {
+	W, H        int
+	Supersample int // N -> NxN samples per pixel, <=1 no antialiasing
+	TileH       int
+	Lap         float64
+
+	z_comp ZCompiler
+}
+
+func NewTiledRender(w, h, supersample int, expression string) TiledRender {
+	return TiledRender{
+		W:           w,
+		H:           h,
+		Supersample: max(supersample, 1),
+		TileH:       DefaultTileH,
+		z_comp:      NewCompiler(expression),
+	}
+}
+
+// color of pixel x,y averaging Supersample x Supersample sub pixels
+func (tr *TiledRender) pixel(x, y int) uint32 {
+	ss := tr.Supersample
+	if ss == 1 {
+		return domainColor(tr.z_comp.execute(mapToPlane(float64(x), float64(y), tr.W, tr.H)))
+	}
+
+	var r, g, b uint32
+	for sy := range ss {
+		for sx := range ss {
+			sub := mapToPlane(float64(x)+(float64(sx)+0.5)/float64(ss), float64(y)+(float64(sy)+0.5)/float64(ss), tr.W, tr.H)
+			c := domainColor(tr.z_comp.execute(sub))
+			r += (c >> 16) & 0xff
+			g += (c >> 8) & 0xff
+			b += c & 0xff
+		}
+	}
+	n := uint32(ss * ss)
+	return 0xff000000 | (r/n)<<16 | (g/n)<<8 | b/n
+}
+
+// render rows y0..y0+len(rows) in parallel, one row of rgb bytes per goroutine item
+func (tr *TiledRender) genTile(y0 int, rows [][]byte) {
+	numCores := runtime.NumCPU()
+
+	var wg sync.WaitGroup
+	wg.Add(numCores)
+
+	for th := range numCores {
+		go func(th int) {
+			defer wg.Done()
+			for iy := th; iy < len(rows); iy += numCores {
+				row := rows[iy]
+				row[0] = 0 // png filter type none
+				for x := range tr.W {
+					c := tr.pixel(x, y0+iy)
+					row[1+x*3+0] = uint8(c >> 16)
+					row[1+x*3+1] = uint8(c >> 8)
+					row[1+x*3+2] = uint8(c)
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+}
+
+// write a png chunk: length, type, data, crc(type+data)
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc.Sum32())
+
+	for _, b := range [][]byte{hdr[:], data, tail[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// every Write becomes an IDAT chunk
+type idatWriter struct {
+	w io.Writer
+}
+
+func (iw idatWriter) Write(p []byte) (int, error) {
+	if err := writeChunk(iw.w, "IDAT", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// render tile by tile streaming the compressed rows as an 8 bit rgb png,
+// memory use is ~ W*TileH*3 bytes regardless of H
+func (tr *TiledRender) Write(w io.Writer) error {
+	if !tr.z_comp.Ok() {
+		return fmt.Errorf("expression error: %s", tr.z_comp.err_message)
+	}
+	t0 := time.Now()
+
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return err
+	}
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(tr.W))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(tr.H))
+	ihdr[8], ihdr[9] = 8, 2 // bit depth, color type rgb
+	if err := writeChunk(bw, "IHDR", ihdr[:]); err != nil {
+		return err
+	}
+
+	idat := bufio.NewWriterSize(idatWriter{bw}, 1<<16) // ~64k IDAT chunks
+	zw := zlib.NewWriter(idat)
+
+	tileH := max(tr.TileH, 1)
+	rows := make([][]byte, tileH)
+	for i := range rows {
+		rows[i] = make([]byte, 1+tr.W*3)
+	}
+
+	for y0 := 0; y0 < tr.H; y0 += tileH {
+		tile := rows[:min(tileH, tr.H-y0)]
+		tr.genTile(y0, tile)
+		for _, row := range tile {
+			if _, err := zw.Write(row); err != nil {
+				return fmt.Errorf("failed to compress row: %w", err)
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := idat.Flush(); err != nil {
+		return err
+	}
+	if err := writeChunk(bw, "IEND", nil); err != nil {
+		return err
+	}
+
+	tr.Lap = float64(time.Since(t0).Milliseconds())
+	return bw.Flush()
+}
+
+func (tr *TiledRender) WritePng(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if err := tr.Write(file); err != nil {
+		return fmt.Errorf("failed to write tiled png %s: %w", filename, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,43 @@
+// render a buddhabrot, anti-buddhabrot or nebulabrot to png
+//
+//	go run ./buddha -samples 20000000 -iters 2000 -out buddha.png
+//	go run ./buddha -nebula -out nebula.png
+//	go run ./buddha -anti -iters 500 -out anti.png
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"mandel/mandel"
+)
+
+func main() {
+	samples := flag.Int("samples", 10_000_000, "random c samples")
+	iters := flag.Int("iters", 1000, "iteration limit")
+	nebula := flag.Bool("nebula", false, "nebulabrot: 5000, 500, 50 iterations in r, g, b")
+	anti := flag.Bool("anti", false, "anti-buddhabrot: orbits not escaping")
+	gamma := flag.Float64("gamma", 2, "tone mapping gamma")
+	seed := flag.Uint64("seed", 1, "random seed")
+	w := flag.Int("w", 1024, "image width")
+	h := flag.Int("h", 1024, "image height")
+	out := flag.String("out", "buddha.png", "output png file")
+	flag.Parse()
+
+	b := mandel.NewBuddhabrot(*samples, *iters)
+	if *nebula {
+		b = mandel.NewNebulabrot(*samples)
+	}
+	b.Anti, b.Gamma, b.Seed = *anti, *gamma, *seed
+
+	m := mandel.NewMandel(*w, *h, 0, 0.5, complex(-2.0, 2.0))
+	if err := m.GenBuddhabrot(context.Background(), b); err != nil {
+		log.Fatal(err)
+	}
+	if err := m.WritePng(*out); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d samples, lap: %v ms\n", *out, b.Samples, m.Lap)
+}
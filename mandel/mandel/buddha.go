@@ -0,0 +1,185 @@
+// buddhabrot, anti-buddhabrot and nebulabrot: orbit density of random samples
+
+package mandel
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type Buddha struct {
+	Samples int    // random c samples
+	Iters   [3]int // r, g, b iteration limits, equal for a plain buddhabrot
+	Anti    bool   // accumulate orbits not escaping within the limit
+	Seed    uint64
+	Gamma   float64 // tone mapping exponent, 0 default
+	Sample  float64 // half side of the sampled square around 0, 0 default
+}
+
+const (
+	defaultGamma      = 2.0
+	defaultSampleSide = 2.0
+)
+
+// buddhabrot with all channels at iters
+func NewBuddhabrot(samples, iters int) Buddha {
+	return Buddha{Samples: samples, Iters: [3]int{iters, iters, iters}}
+}
+
+// nebulabrot: long orbits in red, short in blue
+func NewNebulabrot(samples int) Buddha {
+	return Buddha{Samples: samples, Iters: [3]int{5000, 500, 50}}
+}
+
+// orbit density per rgb channel
+type density [3][]uint32
+
+func newDensity(size int) density {
+	return density{make([]uint32, size), make([]uint32, size), make([]uint32, size)}
+}
+
+// pixel of plane point z, false when outside the view
+func (m *Mandel) complexToPixel(z complex128) (int, bool) {
+	d := z - m.viewCenter()
+	px := m.pixelSize()
+	x := int(math.Floor(real(d)/px + float64(m.w)/2))
+	y := int(math.Floor(imag(d)/px + float64(m.h)/2))
+	if x < 0 || y < 0 || x >= m.w || y >= m.h {
+		return 0, false
+	}
+	return y*m.w + x, true
+}
+
+// escape iteration of p as in genPixel keeping the orbit in buf,
+// returns the orbit and whether it escaped
+func (m *Mandel) orbit(p complex128, limit int, buf []complex128) ([]complex128, bool) {
+	formula := m.Formula
+	if formula == nil {
+		formula = MandelbrotSet{}
+	}
+	z, c := formula.Start(p)
+	buf = buf[:0]
+	for range limit {
+		z = formula.Iterate(z, c)
+		if real(z)*real(z)+imag(z)*imag(z) > 4 {
+			return buf, true
+		}
+		buf = append(buf, z)
+	}
+	return buf, false
+}
+
+// main cardioid and period 2 bulb never escape
+func inMainBulbs(c complex128) bool {
+	x, y := real(c), imag(c)
+	q := (x-0.25)*(x-0.25) + y*y
+	return q*(q+(x-0.25)) <= 0.25*y*y || (x+1)*(x+1)+y*y <= 1.0/16
+}
+
+// accumulate n samples into d
+func (m *Mandel) accumulate(ctx context.Context, b Buddha, n int, rng *rand.Rand, d density) {
+	side := b.Sample
+	if side <= 0 {
+		side = defaultSampleSide
+	}
+	limit := max(b.Iters[0], b.Iters[1], b.Iters[2])
+	skipBulbs := !b.Anti && m.IsMandelbrot()
+	buf := make([]complex128, 0, limit)
+
+	for i := range n {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return
+		}
+		c := complex((rng.Float64()*2-1)*side, (rng.Float64()*2-1)*side)
+		if skipBulbs && inMainBulbs(c) {
+			continue
+		}
+		var escaped bool
+		buf, escaped = m.orbit(c, limit, buf)
+
+		for ch, iters := range b.Iters {
+			// buddhabrot: orbits escaping within iters, anti: the others
+			if escaped && len(buf) < iters {
+				if b.Anti {
+					continue
+				}
+			} else if !b.Anti {
+				continue
+			}
+			for _, z := range buf[:min(len(buf), iters)] {
+				if index, ok := m.complexToPixel(z); ok {
+					d[ch][index]++
+				}
+			}
+		}
+	}
+}
+
+// render the orbit density of b on all cores into the image, each core has its
+// own buffers merged at the end. returns ctx.Err() if cancelled
+func (m *Mandel) GenBuddhabrot(ctx context.Context, b Buddha) error {
+	m.image = make([]uint32, m.size)
+	t0 := time.Now()
+
+	numCores := runtime.NumCPU()
+	parts := make([]density, numCores)
+
+	var wg sync.WaitGroup
+	wg.Add(numCores)
+
+	for core := range numCores {
+		go func() {
+			defer wg.Done()
+			n := b.Samples / numCores
+			if core < b.Samples%numCores {
+				n++
+			}
+			rng := rand.New(rand.NewPCG(b.Seed, uint64(core)))
+			parts[core] = newDensity(m.size)
+			m.accumulate(ctx, b, n, rng, parts[core])
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	total := parts[0]
+	for _, part := range parts[1:] {
+		for ch := range total {
+			for i, v := range part[ch] {
+				total[ch][i] += v
+			}
+		}
+	}
+	m.toneMap(total, b.Gamma)
+
+	m.Lap = float64(time.Since(t0).Milliseconds())
+	return nil
+}
+
+// scale each channel by its max with a 1/gamma power curve
+func (m *Mandel) toneMap(d density, gamma float64) {
+	if gamma <= 0 {
+		gamma = defaultGamma
+	}
+	var levels [3][]uint8
+	for ch := range d {
+		peak := uint32(1)
+		for _, v := range d[ch] {
+			peak = max(peak, v)
+		}
+		levels[ch] = make([]uint8, len(d[ch]))
+		for i, v := range d[ch] {
+			levels[ch][i] = uint8(255 * math.Pow(float64(v)/float64(peak), 1/gamma))
+		}
+	}
+	for i := range m.image {
+		m.image[i] = rgb(levels[0][i], levels[1][i], levels[2][i])
+	}
+}
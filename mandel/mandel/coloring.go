@@ -0,0 +1,348 @@
+// coloring modes and gradient palettes (.map, .ugr, json stops)
+
+package mandel
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ColorMode int
+
+const (
+	ColorIter      ColorMode = iota // palette indexed by iteration count
+	ColorSmooth                     // normalized iteration count
+	ColorHistogram                  // histogram equalized normalized iteration count
+	ColorDistance                   // smooth coloring shaded by distance estimation
+	ColorOrbitTrap                  // min orbit distance to TrapPoint / axes
+)
+
+var ColorModeNames = []string{"iter", "smooth", "histogram", "distance", "orbit trap"}
+
+const (
+	smoothBailout = 1 << 16 // |z|^2 bailout for continuous coloring
+	defaultCycle  = 64      // iterations per palette cycle
+)
+
+// per pixel escape data
+type sample struct {
+	i    int
+	z    complex128 // z at escape
+	der  complex128 // dz/dc (dz/dz0 for julia)
+	trap float64    // min distance to trap
+}
+
+// formulas with a known derivative support distance estimation
+type Deriver interface {
+	Derivative(z, der complex128) complex128
+}
+
+func (MandelbrotSet) Derivative(z, der complex128) complex128 { return 2*z*der + 1 }
+func (Julia) Derivative(z, der complex128) complex128         { return 2 * z * der }
+func (mb Multibrot) Derivative(z, der complex128) complex128 {
+	zn := complex(1, 0)
+	for range mb.N - 1 {
+		zn *= z
+	}
+	return complex(float64(mb.N), 0)*zn*der + 1
+}
+
+func (m *Mandel) bailout() float64 {
+	if m.ColorMode == ColorIter {
+		return 4.0
+	}
+	return smoothBailout
+}
+
+func (m *Mandel) trapDistance(z complex128) float64 {
+	d := z - m.TrapPoint
+	return min(cmplx.Abs(d), math.Abs(real(z)), math.Abs(imag(z)))
+}
+
+// full escape iteration of plane point p keeping z, derivative and orbit trap
+func (m *Mandel) iterate(p complex128) sample {
+	dist := func(z complex128) float64 {
+		return real(z)*real(z) + imag(z)*imag(z)
+	}
+
+	formula := m.Formula
+	if formula == nil {
+		formula = MandelbrotSet{}
+	}
+	deriver, _ := formula.(Deriver)
+
+	z, c := formula.Start(p)
+	s := sample{der: 1, trap: math.Inf(1)}
+	bail := m.bailout()
+
+	var per periodicity
+	for s.i < m.Iters && dist(z) < bail {
+		if deriver != nil {
+			s.der = deriver.Derivative(z, s.der)
+		}
+		z = formula.Iterate(z, c)
+		s.trap = min(s.trap, m.trapDistance(z))
+		s.i++
+		if per.check(z, m.periodEps) {
+			s.i = m.Iters
+		}
+	}
+	s.z = z
+	return s
+}
+
+// store value & shade of a sample for the colorize pass
+func (m *Mandel) store(index int, s sample) {
+	power := 2.0
+	if mb, ok := m.Formula.(Multibrot); ok {
+		power = float64(mb.N)
+	}
+
+	value, shade := -1.0, 1.0 // interior
+	if s.i < m.Iters {
+		value = float64(s.i)
+		if m.ColorMode != ColorIter {
+			// normalized iteration count
+			value = float64(s.i) + 1 - math.Log(math.Log(cmplx.Abs(s.z)))/math.Log(power)
+			value = max(value, 0)
+		}
+		if m.ColorMode == ColorDistance {
+			pixel := m.pixelSize()
+			if der := cmplx.Abs(s.der); der > 0 {
+				az := cmplx.Abs(s.z)
+				de := 0.5 * az * math.Log(az) / der
+				shade = math.Pow(math.Min(de/pixel, 1), 0.25)
+			}
+		}
+	}
+	if m.ColorMode == ColorOrbitTrap {
+		value, shade = s.trap, 1
+	}
+
+	m.values[index], m.shades[index] = value, shade
+}
+
+// map stored values to colors, histogram equalizing if required
+func (m *Mandel) colorize() {
+	palette := m.Palette
+	if palette == nil {
+		palette = FirePalette
+	}
+	cycle := m.ColorCycle
+	if cycle <= 0 {
+		cycle = defaultCycle
+	}
+
+	var cdf []float64
+	if m.ColorMode == ColorHistogram {
+		cdf = make([]float64, m.Iters+2)
+		total := 0.0
+		for _, v := range m.values {
+			if v >= 0 {
+				cdf[min(int(v), m.Iters)+1]++
+				total++
+			}
+		}
+		for i := 1; i < len(cdf); i++ {
+			cdf[i] += cdf[i-1]
+		}
+		for i := range cdf {
+			cdf[i] /= max(total, 1)
+		}
+	}
+
+	for index, v := range m.values {
+		if v < 0 {
+			m.image[index] = 0xff000000
+			continue
+		}
+		var t float64
+		switch m.ColorMode {
+		case ColorHistogram:
+			iv := min(int(v), m.Iters)
+			t = cdf[iv] + (v-math.Floor(v))*(cdf[iv+1]-cdf[iv])
+			t = math.Min(t, 0.999)
+		case ColorOrbitTrap:
+			t = math.Min(math.Sqrt(v), 0.999)
+		default:
+			t = v / cycle
+		}
+		m.image[index] = shadeColor(palette.At(t), m.shades[index])
+	}
+}
+
+func shadeColor(c uint32, k float64) uint32 {
+	if k >= 1 {
+		return c
+	}
+	r, g, b := float64(c&0xff)*k, float64((c>>8)&0xff)*k, float64((c>>16)&0xff)*k
+	return rgb(uint8(r), uint8(g), uint8(b))
+}
+
+// palettes
+
+// colors in the image layout: r low byte, then g, b, alpha 0xff
+type Palette struct {
+	Name   string
+	Colors []uint32
+}
+
+func rgb(r, g, b uint8) uint32 {
+	return 0xff000000 | uint32(b)<<16 | uint32(g)<<8 | uint32(r)
+}
+
+var FirePalette = &Palette{Name: "fire", Colors: fire_pallete_256}
+
+// color at t, cyclic in [0,1), linearly interpolated
+func (p *Palette) At(t float64) uint32 {
+	n := len(p.Colors)
+	if n == 0 {
+		return 0xff000000
+	}
+	t = t - math.Floor(t)
+	x := t * float64(n)
+	i := int(x) % n
+	f := x - math.Floor(x)
+	c0, c1 := p.Colors[i], p.Colors[(i+1)%n]
+
+	lerp := func(shift uint) uint8 {
+		a, b := float64((c0>>shift)&0xff), float64((c1>>shift)&0xff)
+		return uint8(a + (b-a)*f)
+	}
+	return rgb(lerp(0), lerp(8), lerp(16))
+}
+
+// color stop of a gradient, pos in 0..1
+type Stop struct {
+	Pos   float64 `json:"pos"`
+	Color string  `json:"color"` // #rrggbb
+}
+
+// sample sorted stops into a n colors palette
+func NewGradient(name string, stops []Stop, n int) (*Palette, error) {
+	if len(stops) == 0 {
+		return nil, fmt.Errorf("gradient %s has no stops", name)
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Pos < stops[j].Pos })
+
+	cols := make([][3]float64, len(stops))
+	for i, st := range stops {
+		v, err := strconv.ParseUint(strings.TrimPrefix(st.Color, "#"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("gradient %s: bad color %q: %w", name, st.Color, err)
+		}
+		cols[i] = [3]float64{float64((v >> 16) & 0xff), float64((v >> 8) & 0xff), float64(v & 0xff)}
+	}
+
+	p := &Palette{Name: name, Colors: make([]uint32, n)}
+	for k := range n {
+		t := float64(k) / float64(n)
+		j := sort.Search(len(stops), func(j int) bool { return stops[j].Pos > t })
+		var c [3]float64
+		switch {
+		case j == 0:
+			c = cols[0]
+		case j == len(stops):
+			c = cols[len(stops)-1]
+		default:
+			f := (t - stops[j-1].Pos) / (stops[j].Pos - stops[j-1].Pos)
+			for ch := range 3 {
+				c[ch] = cols[j-1][ch] + (cols[j][ch]-cols[j-1][ch])*f
+			}
+		}
+		p.Colors[k] = rgb(uint8(c[0]), uint8(c[1]), uint8(c[2]))
+	}
+	return p, nil
+}
+
+// load a palette by extension: .map (fractint), .ugr (ultra fractal), .json (stops)
+func LoadPalette(filename string) (*Palette, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read palette %s: %w", filename, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".map":
+		return parseMap(name, string(data))
+	case ".ugr":
+		return parseUgr(name, string(data))
+	case ".json":
+		return parseJsonGradient(name, data)
+	}
+	return nil, fmt.Errorf("unknown palette format: %s", filename)
+}
+
+// fractint .map: one "r g b" line per color, text after the 3rd number ignored
+func parseMap(name, data string) (*Palette, error) {
+	p := &Palette{Name: name}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for ln := 1; scanner.Scan(); ln++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		var c [3]uint8
+		for i := range 3 {
+			v, err := strconv.Atoi(fields[i])
+			if err != nil || v < 0 || v > 255 {
+				return nil, fmt.Errorf("%s.map line %d: bad color component %q", name, ln, fields[i])
+			}
+			c[i] = uint8(v)
+		}
+		p.Colors = append(p.Colors, rgb(c[0], c[1], c[2]))
+	}
+	if len(p.Colors) == 0 {
+		return nil, fmt.Errorf("%s.map: no colors", name)
+	}
+	return p, nil
+}
+
+var ugrStop = regexp.MustCompile(`index=(-?\d+)\s+color=(\d+)`)
+
+// first gradient of an ultra fractal .ugr: "index=N color=BGR" stops over 0..399
+func parseUgr(name, data string) (*Palette, error) {
+	if end := strings.Index(data, "}"); end >= 0 {
+		data = data[:end]
+	}
+
+	var stops []Stop
+	for _, match := range ugrStop.FindAllStringSubmatch(data, -1) {
+		index, _ := strconv.Atoi(match[1])
+		bgr, _ := strconv.Atoi(match[2])
+		r, g, b := bgr&0xff, (bgr>>8)&0xff, (bgr>>16)&0xff
+		pos := float64(index) / 400
+		pos -= math.Floor(pos)
+		stops = append(stops, Stop{Pos: pos, Color: fmt.Sprintf("#%02x%02x%02x", r, g, b)})
+	}
+	return NewGradient(name, stops, 256)
+}
+
+// json: [{"pos":0,"color":"#000000"}, ...] or {"name":..., "stops":[...]}
+func parseJsonGradient(name string, data []byte) (*Palette, error) {
+	var stops []Stop
+	if err := json.Unmarshal(data, &stops); err != nil {
+		var grad struct {
+			Name  string `json:"name"`
+			Stops []Stop `json:"stops"`
+		}
+		if err := json.Unmarshal(data, &grad); err != nil {
+			return nil, fmt.Errorf("%s.json: %w", name, err)
+		}
+		if grad.Name != "" {
+			name = grad.Name
+		}
+		stops = grad.Stops
+	}
+	return NewGradient(name, stops, 256)
+}
@@ -0,0 +1,155 @@
+// deep zoom: math/big reference orbit + float64 perturbation with rebasing
+
+package mandel
+
+import (
+	"math"
+	"math/big"
+)
+
+const DeepThreshold = 1e-12 // view width below which perturbation is used
+
+// true when pixels are rendered by perturbation around a big.Float reference orbit,
+// only the Mandelbrot formula is supported
+func (m *Mandel) IsDeep() bool {
+	return m.IsMandelbrot() && (m.Deep || math.Abs(m.rir*m.scale) < DeepThreshold)
+}
+
+// image center in the complex plane, (Range.re+Range.im)/2*scale - Center
+func (m *Mandel) viewCenter() complex128 {
+	mid := (real(m.Range) + imag(m.Range)) / 2 * m.scale
+	return complex(mid-real(m.Center), mid-imag(m.Center))
+}
+
+// precision in bits needed to resolve a pixel at the current zoom
+func (m *Mandel) precision() uint {
+	return uint(max(64, -math.Log2(m.pixelSize())+32))
+}
+
+// set the center discarding any high precision part
+func (m *Mandel) SetCenter(center complex128) {
+	m.Center = center
+	m.lastCenter = center
+	vc := m.viewCenter()
+	m.centerRe, m.centerIm = big.NewFloat(real(vc)), big.NewFloat(imag(vc))
+}
+
+// move Center by delta keeping full precision in the deep center
+func (m *Mandel) Pan(delta complex128) {
+	if m.centerRe == nil {
+		m.SetCenter(m.Center)
+	}
+	prec := m.precision()
+	// Center is negated, a +delta moves the view center by -delta
+	m.centerRe = new(big.Float).SetPrec(prec).Sub(m.centerRe, big.NewFloat(real(delta)))
+	m.centerIm = new(big.Float).SetPrec(prec).Sub(m.centerIm, big.NewFloat(imag(delta)))
+	m.Center += delta
+	m.lastCenter = m.Center
+}
+
+// set the view center from decimal strings of arbitrary precision
+func (m *Mandel) SetCenterString(re, im string) bool {
+	prec := max(m.precision(), uint(len(re)+len(im))*4)
+	cre, ok1 := new(big.Float).SetPrec(prec).SetString(re)
+	cim, ok2 := new(big.Float).SetPrec(prec).SetString(im)
+	if !ok1 || !ok2 {
+		return false
+	}
+	mid := (real(m.Range) + imag(m.Range)) / 2 * m.scale
+	fre, _ := cre.Float64()
+	fim, _ := cim.Float64()
+	m.Center = complex(mid-fre, mid-fim)
+	m.lastCenter = m.Center
+	m.centerRe, m.centerIm = cre, cim
+	return true
+}
+
+// view center as decimal strings with enough digits for the current zoom
+func (m *Mandel) CenterString() (string, string) {
+	if m.centerRe == nil {
+		m.SetCenter(m.Center)
+	}
+	digits := int(float64(m.precision())*math.Log10(2)) + 2
+	return m.centerRe.Text('g', digits), m.centerIm.Text('g', digits)
+}
+
+// Z_0=0, Z_1=C ... at the view center until escape or Iters, stored as complex128
+func (m *Mandel) referenceOrbit() {
+	if m.centerRe == nil {
+		m.SetCenter(m.Center)
+	}
+	prec := m.precision()
+
+	cre := new(big.Float).SetPrec(prec).Set(m.centerRe)
+	cim := new(big.Float).SetPrec(prec).Set(m.centerIm)
+	zre, zim := new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+	zre2, zim2, t := new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+
+	m.refOrbit = make([]complex128, 1, m.Iters+1) // not reused, copies of m may still render
+	for range m.Iters {
+		// z = z^2 + c: re = zre^2 - zim^2 + cre, im = 2 zre zim + cim
+		zre2.Mul(zre, zre)
+		zim2.Mul(zim, zim)
+		t.Mul(zre, zim)
+		zim.Add(t, t).Add(zim, cim)
+		zre.Sub(zre2, zim2).Add(zre, cre)
+
+		fre, _ := zre.Float64()
+		fim, _ := zim.Float64()
+		if fre*fre+fim*fim > 4 {
+			break
+		}
+		m.refOrbit = append(m.refOrbit, complex(fre, fim))
+	}
+}
+
+// escape iterations of pixel c = center + dc following the reference orbit,
+// dz' = 2 Z dz + dz^2 + dc. when |Z+dz| < |dz| the perturbation has lost
+// precision (glitch) or the reference escaped: rebase to Z_0 with dz = Z+dz
+func (m *Mandel) iterateDeep(dc complex128) sample {
+	dist := func(z complex128) float64 {
+		return real(z)*real(z) + imag(z)*imag(z)
+	}
+
+	ref := m.refOrbit
+	if len(ref) < 2 { // reference escaped at once, iterate directly
+		return m.iterate(dc + m.viewCenter())
+	}
+
+	s := sample{der: 1, trap: math.Inf(1)}
+	track := m.values != nil
+	bail := m.bailout()
+
+	dz, n := dc, 1
+	for s.i < m.Iters {
+		z := ref[n] + dz
+		z2 := dist(z)
+		if z2 >= bail {
+			break
+		}
+		if track {
+			s.der = 2*z*s.der + 1
+			s.trap = min(s.trap, m.trapDistance(z))
+		}
+		if z2 < dist(dz) || n == len(ref)-1 {
+			dz, n = z, 0
+		}
+		dz = 2*ref[n]*dz + dz*dz + dc
+		n++
+		s.i++
+	}
+	s.z = ref[n] + dz
+	return s
+}
+
+func (m *Mandel) genPixelDeep(index int) int {
+	dc := m.pixelOffset(float64(index%m.w), float64(index/m.w))
+
+	s := m.iterateDeep(dc)
+	if m.values != nil {
+		m.store(index, s)
+	} else {
+		m.image[index] = m.color(s.i)
+	}
+	return s.i
+}
@@ -0,0 +1,79 @@
+// escape time formulas: mandelbrot, julia, multibrot, burning ship, tricorn
+
+package mandel
+
+import (
+	"fmt"
+	"math"
+)
+
+type Formula interface {
+	Start(p complex128) (z, c complex128) // initial z and constant for plane point p
+	Iterate(z, c complex128) complex128
+	Name() string
+}
+
+type MandelbrotSet struct{}
+
+func (MandelbrotSet) Start(p complex128) (complex128, complex128) { return p, p }
+func (MandelbrotSet) Iterate(z, c complex128) complex128          { return z*z + c }
+func (MandelbrotSet) Name() string                                { return "Mandelbrot" }
+
+// z^2 + C, z0 is the plane point
+type Julia struct {
+	C complex128
+}
+
+func (j Julia) Start(p complex128) (complex128, complex128) { return p, j.C }
+func (Julia) Iterate(z, c complex128) complex128            { return z*z + c }
+func (j Julia) Name() string                                { return fmt.Sprintf("Julia c=%.4g", j.C) }
+
+// z^N + c
+type Multibrot struct {
+	N int
+}
+
+func (Multibrot) Start(p complex128) (complex128, complex128) { return p, p }
+func (mb Multibrot) Iterate(z, c complex128) complex128 {
+	zn := z
+	for range mb.N - 1 {
+		zn *= z
+	}
+	return zn + c
+}
+func (mb Multibrot) Name() string { return fmt.Sprintf("Multibrot z^%d", mb.N) }
+
+// (|re z| + i|im z|)^2 + c
+type BurningShip struct{}
+
+func (BurningShip) Start(p complex128) (complex128, complex128) { return p, p }
+func (BurningShip) Iterate(z, c complex128) complex128 {
+	z = complex(math.Abs(real(z)), math.Abs(imag(z)))
+	return z*z + c
+}
+func (BurningShip) Name() string { return "Burning Ship" }
+
+// conj(z)^2 + c
+type Tricorn struct{}
+
+func (Tricorn) Start(p complex128) (complex128, complex128) { return p, p }
+func (Tricorn) Iterate(z, c complex128) complex128 {
+	z = complex(real(z), -imag(z))
+	return z*z + c
+}
+func (Tricorn) Name() string { return "Tricorn" }
+
+// formulas cycled by the widget
+var Formulas = []Formula{MandelbrotSet{}, Multibrot{N: 3}, Multibrot{N: 4}, BurningShip{}, Tricorn{}}
+
+func (m *Mandel) IsMandelbrot() bool {
+	_, ok := m.Formula.(MandelbrotSet)
+	return m.Formula == nil || ok
+}
+
+func (m *Mandel) FormulaName() string {
+	if m.Formula == nil {
+		return MandelbrotSet{}.Name()
+	}
+	return m.Formula.Name()
+}
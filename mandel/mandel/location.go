@@ -0,0 +1,127 @@
+// view locations saved to / loaded from json files
+
+package mandel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+)
+
+type Location struct {
+	Name      string  `json:"name,omitempty"`
+	Re        string  `json:"re"`    // view center, decimal with full precision
+	Im        string  `json:"im"`    //
+	Range     float64 `json:"range"` // half width of the view
+	Iters     int     `json:"iters"`
+	Color     string  `json:"color,omitempty"`   // ColorModeNames entry
+	Palette   string  `json:"palette,omitempty"` // gradient file, empty for the fire palette
+	JuliaRe   float64 `json:"julia_re,omitempty"`
+	JuliaIm   float64 `json:"julia_im,omitempty"`
+	Julia     bool    `json:"julia,omitempty"`
+	Multibrot int     `json:"multibrot,omitempty"` // power of a multibrot, 0 for none
+	Formula   string  `json:"formula,omitempty"`   // name of another Formulas entry
+}
+
+// current view as a location, palette file name is kept by the caller
+func (m *Mandel) Location() Location {
+	re, im := m.CenterString()
+	l := Location{
+		Re:    re,
+		Im:    im,
+		Range: math.Abs(real(m.Range)),
+		Iters: m.Iters,
+		Color: ColorModeNames[m.ColorMode],
+	}
+	switch f := m.Formula.(type) {
+	case Julia:
+		l.Julia, l.JuliaRe, l.JuliaIm = true, real(f.C), imag(f.C)
+	case Multibrot:
+		l.Multibrot = f.N
+	case BurningShip, Tricorn:
+		l.Formula = f.Name()
+	}
+	return l
+}
+
+// set view, iterations, coloring and formula from l
+func (m *Mandel) SetLocation(l Location) error {
+	if l.Range <= 0 {
+		return fmt.Errorf("location %q: range must be positive", l.Name)
+	}
+	m.Range = complex(-l.Range, l.Range)
+	if l.Iters > 0 {
+		m.Iters = l.Iters
+	}
+	m.Update()
+
+	if !m.SetCenterString(l.Re, l.Im) {
+		return fmt.Errorf("location %q: bad center %s, %s", l.Name, l.Re, l.Im)
+	}
+
+	if l.Color != "" {
+		mode := slices.Index(ColorModeNames, l.Color)
+		if mode < 0 {
+			return fmt.Errorf("location %q: unknown color mode %s", l.Name, l.Color)
+		}
+		m.ColorMode = ColorMode(mode)
+	}
+	if l.Palette != "" {
+		palette, err := LoadPalette(l.Palette)
+		if err != nil {
+			return err
+		}
+		m.Palette = palette
+	}
+
+	switch {
+	case l.Julia:
+		m.Formula = Julia{C: complex(l.JuliaRe, l.JuliaIm)}
+	case l.Multibrot > 0:
+		m.Formula = Multibrot{N: l.Multibrot}
+	case l.Formula != "":
+		i := slices.IndexFunc(Formulas, func(f Formula) bool { return f.Name() == l.Formula })
+		if i < 0 {
+			return fmt.Errorf("location %q: unknown formula %s", l.Name, l.Formula)
+		}
+		m.Formula = Formulas[i]
+	default:
+		m.Formula = MandelbrotSet{}
+	}
+	return nil
+}
+
+func LoadLocations(filename string) ([]Location, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read locations %s: %w", filename, err)
+	}
+	var locs []Location
+	if err := json.Unmarshal(data, &locs); err != nil {
+		return nil, fmt.Errorf("failed to parse locations %s: %w", filename, err)
+	}
+	return locs, nil
+}
+
+func SaveLocations(filename string, locs []Location) error {
+	data, err := json.MarshalIndent(locs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write locations %s: %w", filename, err)
+	}
+	return nil
+}
+
+// append l to the locations file, creating it if missing
+func AppendLocation(filename string, l Location) error {
+	locs, err := LoadLocations(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return SaveLocations(filename, append(locs, l))
+}
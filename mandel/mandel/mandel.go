@@ -3,17 +3,19 @@
 package mandel
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"image"
 	"image/png"
 	"math"
+	"math/big"
 	"os"
-	"runtime"
-	"sync"
 	"time"
 )
 
+const viewScale = 0.8 // shorter image side spans 0.8*Range width
+
 var fire_pallete_256 = []uint32{0, 0, 4, 12, 16, 24, 32, 36, 44, 48, 56, 64, 68, 76, 80, 88, 96,
 	100, 108, 116, 120, 128, 132, 140, 148, 152, 160, 164, 172, 180, 184, 192, 200, 1224, 3272,
 	4300, 6348, 7376, 9424, 10448, 12500, 14548, 15576, 17624, 18648, 20700, 21724, 23776, 25824,
@@ -41,30 +43,90 @@ type Mandel struct {
 	Center complex128 // f64 + f64 i
 	Range complex128
 
-	cr    complex128
-	rir   float64
-	scale float64
+	rir   float64 // Range width
+	scale float64 // view width factor, the shorter image side spans rir*scale
 	image []uint32
 	Lap   float64
+
+	Formula Formula // nil is Mandelbrot
+
+	ColorMode  ColorMode
+	Palette    *Palette   // nil is the fire palette
+	ColorCycle float64    // iterations per palette cycle, 0 default
+	TrapPoint  complex128 // orbit trap point
+	values     []float64  // per pixel value for the colorize pass
+	shades     []float64
+
+	Deep               bool       // force perturbation rendering, automatic below DeepThreshold
+	centerRe, centerIm *big.Float // high precision view center
+	lastCenter         complex128 // Center the big center was synced to
+	refOrbit           []complex128
+
+	dwell     []int32 // iterations per pixel, -1 not yet computed
+	periodEps float64 // periodicity check tolerance
 }
 
 func NewMandel(w, h, iters int, center complex128, range_ complex128) Mandel {
-	return Mandel{w: w, h: h, Iters: iters, size: w * h, Center: center, Range: range_, cr: complex(real(range_), real(range_)), rir: imag(range_) - real(range_), scale: 0.8 * float64(w) / float64(h)}
+	m := Mandel{w: w, h: h, Iters: iters, size: w * h, Center: center, Range: range_, rir: imag(range_) - real(range_), scale: viewScale}
+	m.SetCenter(center)
+	return m
 }
 
 func (m *Mandel) Update() {
-	m.cr = complex(real(m.Range), real(m.Range))
 	m.rir = imag(m.Range) - real(m.Range)
-	m.scale = 0.8 * float64(m.w) / float64(m.h)
+	m.scale = viewScale
+
+	if m.Center != m.lastCenter { // Center assigned directly, high precision part is lost
+		m.SetCenter(m.Center)
+	}
 }
 
-func (m *Mandel) genPixel(index int) {
-	doScale := func(iw, jh int) complex128 {
-		c00 := m.cr + complex(m.rir*float64(iw)/float64(m.w), m.rir*float64(jh)/float64(m.h))
-		return complex(real(c00)*m.scale-real(m.Center), imag(c00)*m.scale-imag(m.Center))
+// prepare the reference orbit in deep mode and colorize buffers
+func (m *Mandel) prepare() {
+	if m.IsDeep() {
+		m.referenceOrbit()
+	} else {
+		m.refOrbit = nil
 	}
+	if m.needsColorize() {
+		m.values, m.shades = make([]float64, m.size), make([]float64, m.size)
+	} else {
+		m.values, m.shades = nil, nil
+	}
+	m.periodEps = min(1e-10, m.pixelSize()*1e-3)
+}
+
+// anything but iteration coloring with the default palette colors in a second pass
+func (m *Mandel) needsColorize() bool {
+	return m.ColorMode != ColorIter || m.Palette != nil
+}
 
-	c0 := doScale(index%m.w, index/m.w)
+func (m *Mandel) finish() {
+	if m.values != nil {
+		m.colorize()
+	}
+}
+
+func (m *Mandel) color(i int) uint32 {
+	if i != m.Iters {
+		return 0xff000000 | fire_pallete_256[(i<<2)%len(fire_pallete_256)]
+	}
+	return 0xff000000
+}
+
+// color pixel index, returns its iteration count
+func (m *Mandel) genPixel(index int) int {
+	if m.refOrbit != nil {
+		return m.genPixelDeep(index)
+	}
+
+	c0 := m.PixelToComplex(float64(index%m.w), float64(index/m.w))
+
+	if m.values != nil {
+		s := m.iterate(c0)
+		m.store(index, s)
+		return s.i
+	}
 
 	z := c0
 	i := 0
@@ -73,51 +135,89 @@ func (m *Mandel) genPixel(index int) {
 		return real(z)*real(z) + imag(z)*imag(z)
 	}
 
-	for i < m.Iters && dist(z) < 4.0 {
-		z = z*z + c0
-		i++
+	var p periodicity
+	if m.IsMandelbrot() { // inlined fast path
+		for i < m.Iters && dist(z) < 4.0 {
+			z = z*z + c0
+			i++
+			if p.check(z, m.periodEps) {
+				i = m.Iters
+			}
+		}
+	} else {
+		z, c := m.Formula.Start(c0)
+		for i < m.Iters && dist(z) < 4.0 {
+			z = m.Formula.Iterate(z, c)
+			i++
+			if p.check(z, m.periodEps) {
+				i = m.Iters
+			}
+		}
 	}
 
-	if i != m.Iters {
-		m.image[index] = 0xff000000 | fire_pallete_256[(i<<2)%len(fire_pallete_256)]
-	} else {
-		m.image[index] = 0xff000000
+	m.image[index] = m.color(i)
+	return i
+}
+
+// brent's cycle detection: compare z with a saved value refreshed at doubling intervals
+type periodicity struct {
+	saved        complex128
+	step, period int
+}
+
+// true when z repeats the saved value, i.e. the orbit is periodic and c interior
+func (p *periodicity) check(z complex128, eps float64) bool {
+	if math.Abs(real(z)-real(p.saved)) < eps && math.Abs(imag(z)-imag(p.saved)) < eps {
+		return true
 	}
+	p.step++
+	if p.step >= p.period {
+		p.saved, p.step, p.period = z, 0, max(2*p.period, 8)
+	}
+	return false
+}
+
+// side of a square pixel in the complex plane
+func (m *Mandel) pixelSize() float64 {
+	return math.Abs(m.rir*m.scale) / float64(min(m.w, m.h))
+}
+
+// offset of pixel iw, jh from the view center
+func (m *Mandel) pixelOffset(iw, jh float64) complex128 {
+	px := m.pixelSize()
+	return complex((iw-float64(m.w)/2)*px, (jh-float64(m.h)/2)*px)
+}
+
+// pixel iw, jh in w x h to the complex plane
+func (m *Mandel) PixelToComplex(iw, jh float64) complex128 {
+	return m.viewCenter() + m.pixelOffset(iw, jh)
+}
+
+func (m *Mandel) Size() (int, int) {
+	return m.w, m.h
+}
+
+// new image size keeping view center and pixel scale of the shorter side
+func (m *Mandel) Resize(w, h int) {
+	m.w, m.h, m.size = w, h, w*h
+	m.Update()
 }
 
 func (m *Mandel) GenImageSt() {
 	m.image = make([]uint32, m.size)
 
 	t0 := time.Now()
+	m.prepare()
 
 	for index := 0; index < m.size; index++ {
 		m.genPixel(index)
 	}
+	m.finish()
 	m.Lap = float64(time.Since(t0).Milliseconds())
 }
 
 func (m *Mandel) GenImage() {
-	m.image = make([]uint32, m.size)
-
-	numCores := runtime.NumCPU()
-	itemsPerCore := m.size / numCores
-
-	t0 := time.Now()
-
-	var wg sync.WaitGroup
-	wg.Add(numCores)
-
-	for th := range numCores {
-		go func(th int) {
-			defer wg.Done()
-			for index := th * itemsPerCore; index < min((th+1)*itemsPerCore, m.size); index++ {
-				m.genPixel(index)
-			}
-		}(th)
-	}
-	wg.Wait()
-
-	m.Lap = float64(time.Since(t0).Milliseconds())
+	m.GenImageCtx(context.Background())
 }
 
 func (m *Mandel) GenerateImage() image.Image {
@@ -179,19 +279,28 @@ func Mandelbrot(w, h, iters int, center, range_ complex128) image.Image {
 	return m.GenerateImage()
 }
 
-// x, y in w,h generate a new center, range_ and update mandel
+// x, y in w,h generate a new center, range_ and update mandel:
+// center on the pixel and zoom in 2x
 func (m *Mandel) Recalculate(x, y float64) (complex128, complex128) {
-	w, h := float64(m.w), float64(m.h)
-	dist := float64(w / 2)
-	rx := dist / w
-	ry := dist / h
-	ratio := math.Abs(real(m.Range))
-
-	m.Center += complex(ratio*(w/2-x)/w, ratio*(h/2-y)/h)
-	m.Range = complex(real(m.Range)*rx, imag(m.Range)*ry)
+	m.Pan(-m.pixelOffset(x, y))
+	m.Range *= 0.5
 
 	m.Update()
 	return m.Center, m.Range
 }
 
+// scale the view by factor around pixel x, y which stays in place, <1 zooms in
+func (m *Mandel) ZoomAt(x, y, factor float64) (complex128, complex128) {
+	m.Pan(-m.pixelOffset(x, y) * complex(1-factor, 0))
+	m.Range *= complex(factor, 0)
 
+	m.Update()
+	return m.Center, m.Range
+}
+
+// move the view by dx, dy pixels
+func (m *Mandel) PanPixels(dx, dy float64) complex128 {
+	px := m.pixelSize()
+	m.Pan(complex(dx*px, dy*px))
+	return m.Center
+}
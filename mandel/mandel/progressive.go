@@ -0,0 +1,172 @@
+// tile scheduled, mariani-silver subdivided, cancellable and progressive rendering
+
+package mandel
+
+import (
+	"context"
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tileSize = 64 // tile side, tiles are pulled by workers from a shared counter
+	minRect  = 6  // rects with a side below this are computed pixel by pixel
+)
+
+var PreviewFactors = []int{8, 2} // coarse preview downscale factors
+
+// true when escape time level sets are connected so a uniform rectangle border
+// implies a uniform interior (mariani-silver)
+func (m *Mandel) subdivide() bool {
+	switch m.Formula.(type) {
+	case nil, MandelbrotSet, Julia, Multibrot:
+		return true
+	}
+	return false
+}
+
+func (m *Mandel) pixel(x, y int) int32 {
+	index := y*m.w + x
+	if m.dwell[index] < 0 {
+		m.dwell[index] = int32(m.genPixel(index))
+	}
+	return m.dwell[index]
+}
+
+// mariani-silver on [x0,x1) x [y0,y1): compute the border, fill the rect if it's
+// uniform, otherwise split in 4 and recurse
+func (m *Mandel) genRect(x0, y0, x1, y1 int) {
+	if !m.subdivide() || x1-x0 < minRect || y1-y0 < minRect {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				m.pixel(x, y)
+			}
+		}
+		return
+	}
+
+	d := m.pixel(x0, y0)
+	uniform := true
+	for x := x0; x < x1; x++ {
+		uniform = m.pixel(x, y0) == d && uniform
+		uniform = m.pixel(x, y1-1) == d && uniform
+	}
+	for y := y0; y < y1; y++ {
+		uniform = m.pixel(x0, y) == d && uniform
+		uniform = m.pixel(x1-1, y) == d && uniform
+	}
+
+	// colorize modes only share the interior value, orbit traps none
+	fill := uniform && (m.values == nil || (int(d) == m.Iters && m.ColorMode != ColorOrbitTrap))
+	if fill {
+		border := y0*m.w + x0
+		for y := y0 + 1; y < y1-1; y++ {
+			for x := x0 + 1; x < x1-1; x++ {
+				index := y*m.w + x
+				m.dwell[index] = d
+				m.image[index] = m.image[border]
+				if m.values != nil {
+					m.values[index], m.shades[index] = m.values[border], m.shades[border]
+				}
+			}
+		}
+		return
+	}
+
+	xm, ym := (x0+x1)/2, (y0+y1)/2
+	m.genRect(x0, y0, xm, ym)
+	m.genRect(xm, y0, x1, ym)
+	m.genRect(x0, ym, xm, y1)
+	m.genRect(xm, ym, x1, y1)
+}
+
+// render by tiles on all cores, returns ctx.Err() if cancelled before completion
+func (m *Mandel) GenImageCtx(ctx context.Context) error {
+	m.image = make([]uint32, m.size)
+
+	t0 := time.Now()
+	m.prepare()
+
+	m.dwell = make([]int32, m.size)
+	for i := range m.dwell {
+		m.dwell[i] = -1
+	}
+
+	tilesX, tilesY := (m.w+tileSize-1)/tileSize, (m.h+tileSize-1)/tileSize
+	ntiles := int64(tilesX * tilesY)
+	var next atomic.Int64
+
+	numCores := runtime.NumCPU()
+
+	var wg sync.WaitGroup
+	wg.Add(numCores)
+
+	for range numCores {
+		go func() {
+			defer wg.Done()
+			for {
+				tile := next.Add(1) - 1
+				if tile >= ntiles || ctx.Err() != nil {
+					return
+				}
+				tx, ty := int(tile)%tilesX, int(tile)/tilesX
+				m.genRect(tx*tileSize, ty*tileSize, min((tx+1)*tileSize, m.w), min((ty+1)*tileSize, m.h))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.finish()
+
+	m.Lap = float64(time.Since(t0).Milliseconds())
+	return nil
+}
+
+// render downscaled previews by PreviewFactors then the full image, calling
+// preview with each upscaled result. stops with ctx.Err() when cancelled
+func (m *Mandel) GenImageProgressive(ctx context.Context, preview func(image.Image)) error {
+	for _, f := range PreviewFactors {
+		if m.w/f < 16 || m.h/f < 16 {
+			continue
+		}
+		sub := *m
+		sub.w, sub.h = m.w/f, m.h/f
+		sub.size = sub.w * sub.h
+		sub.Update()
+
+		if err := sub.GenImageCtx(ctx); err != nil {
+			return err
+		}
+		if preview != nil {
+			preview(upscale(sub.GenerateImage().(*image.RGBA), m.w, m.h))
+		}
+	}
+
+	if err := m.GenImageCtx(ctx); err != nil {
+		return err
+	}
+	if preview != nil {
+		preview(m.GenerateImage())
+	}
+	return nil
+}
+
+// nearest neighbour resize
+func upscale(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		srow := src.Pix[(y*sh/h)*src.Stride:]
+		drow := dst.Pix[y*dst.Stride:]
+		for x := range w {
+			copy(drow[x*4:x*4+4], srow[(x*sw/w)*4:])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,155 @@
+// exponential zoom between keyframe locations rendered to png sequence or animated gif
+
+package mandel
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// auto scaled iterations: base + base/2 per decade of zoom from r0
+func AutoIters(base int, r0, r float64) int {
+	return base + int(float64(base)/2*max(0, math.Log10(r0/r)))
+}
+
+// n frames from a up to b (excluded): range is exponentially interpolated and the
+// center moves proportionally to the zoomed distance so b's center stays fixed.
+// a zero Iters in b is auto scaled with depth from a
+func ZoomFrames(a, b Location, n int) ([]Location, error) {
+	prec := uint(max(len(a.Re), len(a.Im), len(b.Re), len(b.Im))*4 + 64)
+	parse := func(s string) (*big.Float, error) {
+		f, ok := new(big.Float).SetPrec(prec).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("bad center coordinate %q", s)
+		}
+		return f, nil
+	}
+	are, err := parse(a.Re)
+	if err != nil {
+		return nil, err
+	}
+	aim, err := parse(a.Im)
+	if err != nil {
+		return nil, err
+	}
+	bre, err := parse(b.Re)
+	if err != nil {
+		return nil, err
+	}
+	bim, err := parse(b.Im)
+	if err != nil {
+		return nil, err
+	}
+	dre := new(big.Float).SetPrec(prec).Sub(bre, are)
+	dim := new(big.Float).SetPrec(prec).Sub(bim, aim)
+
+	digits := int(float64(prec) * math.Log10(2))
+
+	frames := make([]Location, 0, n)
+	for k := range n {
+		t := float64(k) / float64(n)
+		r := a.Range * math.Pow(b.Range/a.Range, t)
+
+		s := t
+		if a.Range != b.Range {
+			s = (a.Range - r) / (a.Range - b.Range)
+		}
+		bs := new(big.Float).SetPrec(prec).SetFloat64(s)
+		re := new(big.Float).SetPrec(prec).Mul(dre, bs)
+		im := new(big.Float).SetPrec(prec).Mul(dim, bs)
+		re.Add(re, are)
+		im.Add(im, aim)
+
+		f := a
+		f.Name = fmt.Sprintf("%s %d", a.Name, k)
+		f.Re, f.Im, f.Range = re.Text('g', digits), im.Text('g', digits), r
+		if b.Iters > 0 {
+			f.Iters = int(float64(a.Iters) * math.Pow(float64(b.Iters)/float64(a.Iters), t))
+		} else {
+			f.Iters = AutoIters(a.Iters, a.Range, r)
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// frames through all keyframes, framesPerKey between each pair plus the last one
+func KeyframeZoom(keys []Location, framesPerKey int) ([]Location, error) {
+	if len(keys) < 2 {
+		return nil, fmt.Errorf("zoom needs at least 2 keyframes, got %d", len(keys))
+	}
+	if keys[0].Iters <= 0 {
+		return nil, fmt.Errorf("first keyframe needs iterations")
+	}
+
+	keys = slices.Clone(keys)
+	for i := range keys { // auto scaled keyframes relative to the first one
+		if keys[i].Iters <= 0 {
+			keys[i].Iters = AutoIters(keys[0].Iters, keys[0].Range, keys[i].Range)
+		}
+	}
+
+	var frames []Location
+	for i := 0; i < len(keys)-1; i++ {
+		seg, err := ZoomFrames(keys[i], keys[i+1], framesPerKey)
+		if err != nil {
+			return nil, fmt.Errorf("keyframe %d: %w", i, err)
+		}
+		frames = append(frames, seg...)
+	}
+	return append(frames, keys[len(keys)-1]), nil
+}
+
+// render frames at w x h to out: a .gif file or a directory of frame#####.png
+func RenderZoom(w, h int, frames []Location, out string, progress func(int, *Mandel)) error {
+	isGif := strings.EqualFold(filepath.Ext(out), ".gif")
+	if !isGif {
+		if err := os.MkdirAll(out, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", out, err)
+		}
+	}
+
+	anim := gif.GIF{}
+	for i, f := range frames {
+		m := NewMandel(w, h, f.Iters, 0, complex(-f.Range, f.Range))
+		if err := m.SetLocation(f); err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+		m.GenImage()
+
+		if isGif {
+			img := m.GenerateImage()
+			pal := image.NewPaletted(img.Bounds(), palette.Plan9)
+			draw.FloydSteinberg.Draw(pal, img.Bounds(), img, image.Point{})
+			anim.Image = append(anim.Image, pal)
+			anim.Delay = append(anim.Delay, 4) // 25 fps
+		} else if err := m.WritePng(filepath.Join(out, fmt.Sprintf("frame%05d.png", i))); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(i, &m)
+		}
+	}
+
+	if isGif {
+		file, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", out, err)
+		}
+		defer file.Close()
+		if err := gif.EncodeAll(file, &anim); err != nil {
+			return fmt.Errorf("failed to encode gif %s: %w", out, err)
+		}
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	// "fmt"
+	"context"
 	"fmt"
+	"image"
 	"log"
 	"math"
 	"os"
@@ -12,6 +14,7 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
 )
 
@@ -23,7 +26,11 @@ const (
 	Range  = complex(-2.0, 2.0)
 	Iters  = 200
 
-	offset = 0.03
+	offset     = 0.03
+	wheelZoom  = 0.8 // zoom factor per wheel notch
+	maxHistory = 100 // views kept for undo
+
+	locationsFile = "locations.json"
 )
 
 // tap mandel widget
@@ -36,6 +43,17 @@ type MandelWidget struct {
 	Center, CenterInit complex128
 	Range, RangeInit   complex128
 	Iters, ItersInit   int
+	formula            int // index in mandel.Formulas
+
+	shown  mandel.Mandel      // last completely rendered
+	cancel context.CancelFunc // cancels the render in progress
+
+	paletteFile string // gradient file of Mnd.Palette
+	location    int    // next location to load from locationsFile
+
+	history  []mandel.Location // previous views, last on top
+	mouse    fyne.Position     // last hovered position
+	dragging bool
 }
 
 func NewTapImage(w, h, iters int, Center, Range complex128, win fyne.Window) *MandelWidget {
@@ -45,6 +63,7 @@ func NewTapImage(w, h, iters int, Center, Range complex128, win fyne.Window) *Ma
 	ti := &MandelWidget{
 		img:        canvas.NewImageFromImage(mnd.GenerateImage()),
 		Mnd:        mnd,
+		shown:      mnd,
 		Center:     Center,
 		Range:      Range,
 		Iters:      iters,
@@ -55,26 +74,84 @@ func NewTapImage(w, h, iters int, Center, Range complex128, win fyne.Window) *Ma
 	}
 
 	ti.ExtendBaseWidget(ti)
-	ti.img.FillMode = canvas.ImageFillOriginal
+	ti.img.FillMode = canvas.ImageFillStretch // image is rendered at the widget pixel size
 
 	return ti
 }
 
+// render a copy of Mnd in background showing coarse previews first,
+// a new update cancels the render in progress
 func (ti *MandelWidget) update() {
 	ti.Mnd.Iters, ti.Mnd.Center, ti.Mnd.Range = ti.Iters, ti.Center, ti.Range
 
 	ti.Mnd.Update()
-	ti.Mnd.GenImage()
 
-	ti.img.Image = ti.Mnd.GenerateImage()
-	ti.img.Refresh()
+	if ti.cancel != nil {
+		ti.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ti.cancel = cancel
+
+	mnd := ti.Mnd
+	ti.setTitle(&mnd, ", rendering...")
+
+	go func() {
+		err := mnd.GenImageProgressive(ctx, func(img image.Image) {
+			fyne.Do(func() {
+				if ctx.Err() == nil {
+					ti.img.Image = img
+					ti.img.Refresh()
+				}
+			})
+		})
+		if err != nil { // cancelled
+			return
+		}
+		fyne.Do(func() {
+			if ctx.Err() == nil {
+				ti.shown = mnd
+				ti.setTitle(&mnd, "")
+			}
+		})
+	}()
+}
 
-	ti.win.SetTitle(fmt.Sprintf("Mandelbrot fractal %v x %v, iters: %v, lap: %v ms, range: %.2g", w, h, ti.Iters, ti.Mnd.Lap, math.Abs(real(ti.Range))))
+func (ti *MandelWidget) setTitle(mnd *mandel.Mandel, status string) {
+	if mnd.IsDeep() {
+		status = ", deep" + status
+	}
+	w, h := mnd.Size()
+	ti.win.SetTitle(fmt.Sprintf("%s fractal %v x %v, iters: %v, lap: %v ms, range: %.2g, color: %s%s", mnd.FormulaName(), w, h, mnd.Iters, mnd.Lap, math.Abs(real(mnd.Range)), mandel.ColorModeNames[mnd.ColorMode], status))
 }
 
 func (ti *MandelWidget) reset() {
 	ti.Center, ti.Range, ti.Iters = ti.CenterInit, ti.RangeInit, ti.ItersInit
+	ti.Mnd.SetCenter(ti.Center)
+
+	ti.update()
+}
+
+// save the current view for undo
+func (ti *MandelWidget) push() {
+	ti.history = append(ti.history, ti.Mnd.Location())
+	if len(ti.history) > maxHistory {
+		ti.history = ti.history[1:]
+	}
+}
 
+// back to the previous view
+func (ti *MandelWidget) undo() {
+	if len(ti.history) == 0 {
+		return
+	}
+	l := ti.history[len(ti.history)-1]
+	ti.history = ti.history[:len(ti.history)-1]
+
+	if err := ti.Mnd.SetLocation(l); err != nil {
+		log.Print(err)
+		return
+	}
+	ti.Center, ti.Range, ti.Iters = ti.Mnd.Center, ti.Mnd.Range, ti.Mnd.Iters
 	ti.update()
 }
 
@@ -82,34 +159,126 @@ func (ti *MandelWidget) save_last() {
 	for fn := 0; ; fn++ {
 		fname := fmt.Sprintf("mandel%v.png", fn)
 		if _, err := os.Stat(fname); os.IsNotExist(err) {
-			ti.Mnd.WritePng(fname)
+			ti.shown.WritePng(fname)
 			break
 		}
 	}
 }
 
+// append the current view to locationsFile
+func (ti *MandelWidget) save_location() {
+	l := ti.Mnd.Location()
+	l.Name = fmt.Sprintf("%s %.2g", ti.Mnd.FormulaName(), l.Range)
+	l.Palette = ti.paletteFile
+	if err := mandel.AppendLocation(locationsFile, l); err != nil {
+		log.Print(err)
+	}
+}
+
+// cycle through the views saved in locationsFile
+func (ti *MandelWidget) load_location() {
+	locs, err := mandel.LoadLocations(locationsFile)
+	if err != nil || len(locs) == 0 {
+		log.Print("no locations: ", err)
+		return
+	}
+	l := locs[ti.location%len(locs)]
+	ti.location++
+
+	if err := ti.Mnd.SetLocation(l); err != nil {
+		log.Print(err)
+		return
+	}
+	ti.paletteFile = l.Palette
+	ti.Center, ti.Range, ti.Iters = ti.Mnd.Center, ti.Mnd.Range, ti.Mnd.Iters
+}
+
 func (ti *MandelWidget) CreateRenderer() fyne.WidgetRenderer { return widget.NewSimpleRenderer(ti.img) }
 
-func (ti *MandelWidget) Tapped(event *fyne.PointEvent) {
+// widget position to image pixels
+func (ti *MandelWidget) pixels(pos fyne.Position) (float64, float64) {
 	scale := ti.win.Canvas().Scale() // scale to tpi value
+	return float64(scale * pos.X), float64(scale * pos.Y)
+}
+
+// center on the tapped point and zoom in 2x
+func (ti *MandelWidget) Tapped(event *fyne.PointEvent) {
+	ti.push()
+	x, y := ti.pixels(event.Position)
+	ti.Center, ti.Range = ti.Mnd.Recalculate(x, y)
+	ti.update()
+}
+
+// zoom out 2x around the tapped point
+func (ti *MandelWidget) TappedSecondary(event *fyne.PointEvent) {
+	ti.push()
+	x, y := ti.pixels(event.Position)
+	ti.Center, ti.Range = ti.Mnd.ZoomAt(x, y, 2)
+	ti.update()
+}
 
-	x, y := scale * event.Position.X, scale * event.Position.Y
+// wheel zoom keeping the point under the cursor in place
+func (ti *MandelWidget) Scrolled(event *fyne.ScrollEvent) {
+	ti.push()
+	x, y := ti.pixels(event.Position)
+	factor := math.Pow(wheelZoom, float64(event.Scrolled.DY)/10) // ~10 units per notch
+	ti.Center, ti.Range = ti.Mnd.ZoomAt(x, y, factor)
+	ti.update()
+}
 
-	ti.Center, ti.Range = ti.Mnd.Recalculate(float64(x), float64(y))
+// drag to pan, the whole drag is a single undo step
+func (ti *MandelWidget) Dragged(event *fyne.DragEvent) {
+	if !ti.dragging {
+		ti.push()
+		ti.dragging = true
+	}
+	dx, dy := ti.pixels(fyne.NewPos(event.Dragged.DX, event.Dragged.DY))
+	ti.Center = ti.Mnd.PanPixels(dx, dy)
 	ti.update()
+}
 
+func (ti *MandelWidget) DragEnd() { ti.dragging = false }
 
-	//log.Printf("win size: %v x %v", ti.win.Canvas().Size().Width, ti.win.Canvas().Size().Height)
+func (ti *MandelWidget) MouseIn(event *desktop.MouseEvent)    { ti.mouse = event.Position }
+func (ti *MandelWidget) MouseMoved(event *desktop.MouseEvent) { ti.mouse = event.Position }
+func (ti *MandelWidget) MouseOut()                            {}
+
+// render at the new widget size in pixels
+func (ti *MandelWidget) Resize(size fyne.Size) {
+	ti.BaseWidget.Resize(size)
+
+	scale := ti.win.Canvas().Scale()
+	w, h := int(size.Width*scale), int(size.Height*scale)
+	if mw, mh := ti.Mnd.Size(); w < 1 || h < 1 || (w == mw && h == mh) {
+		return
+	}
+	ti.Mnd.Resize(w, h)
+	ti.update()
 }
 
-func (ti *MandelWidget) TappedSecondary(event *fyne.PointEvent) {}
-// func (ti *MandelWidget) Resize(size fyne.Size) {
-	
-// 	ti.BaseWidget.Resize(size)
-// 	ti.Mnd = mandel.NewMandel(int(size.Width), int(size.Height), ti.Iters, ti.Center, ti.Range)
-// 	ti.update()
-// }
+// on the Mandelbrot view show the Julia set of the point under the cursor,
+// on any other formula go back to the Mandelbrot set
+func (ti *MandelWidget) julia() {
+	if ti.Mnd.IsMandelbrot() {
+		c := ti.Mnd.PixelToComplex(ti.pixels(ti.mouse))
+		ti.Mnd.Formula = mandel.Julia{C: c}
+		ti.Center, ti.Range = 0, ti.RangeInit
+		ti.Mnd.SetCenter(ti.Center)
+	} else {
+		ti.formula = 0
+		ti.Mnd.Formula = mandel.Formulas[0]
+		ti.Center, ti.Range, ti.Iters = ti.CenterInit, ti.RangeInit, ti.ItersInit
+		ti.Mnd.SetCenter(ti.Center)
+	}
+}
 
+// cycle through mandel.Formulas from the initial view
+func (ti *MandelWidget) nextFormula() {
+	ti.formula = (ti.formula + 1) % len(mandel.Formulas)
+	ti.Mnd.Formula = mandel.Formulas[ti.formula]
+	ti.Center, ti.Range, ti.Iters = ti.CenterInit, ti.RangeInit, ti.ItersInit
+	ti.Mnd.SetCenter(ti.Center)
+}
 
 ////////////////////////////////
 
@@ -124,7 +293,23 @@ func main() {
 
 	tapImage := NewTapImage(w, h, Iters, Center, Range, win)
 
+	if len(os.Args) > 1 { // optional gradient palette: .map, .ugr or .json
+		if palette, err := mandel.LoadPalette(os.Args[1]); err != nil {
+			log.Print(err)
+		} else {
+			tapImage.Mnd.Palette = palette
+			tapImage.paletteFile = os.Args[1]
+			tapImage.update()
+		}
+	}
+
 	canvas.SetOnTypedKey(func(key *fyne.KeyEvent) {
+		switch key.Name {
+		case fyne.KeyEscape, fyne.KeyS, fyne.KeyL, fyne.KeyBackspace, fyne.KeyU:
+		default: // view changes can be undone
+			tapImage.push()
+		}
+
 		switch key.Name {
 		case fyne.KeyEscape:
 			win.Close()
@@ -133,6 +318,10 @@ func main() {
 		case fyne.KeySpace:
 			tapImage.reset()
 
+		case fyne.KeyBackspace, fyne.KeyU: // undo
+			tapImage.undo()
+			return
+
 		case fyne.KeyPlus:
 			tapImage.Iters *= 2
 		case fyne.KeyMinus:
@@ -141,13 +330,29 @@ func main() {
 				tapImage.Iters = 2
 			}
 		case fyne.KeyLeft:
-			tapImage.Center -= complex(offset*math.Abs(real(tapImage.Range)), 0.0)
+			tapImage.Mnd.Pan(-complex(offset*math.Abs(real(tapImage.Range)), 0.0))
+			tapImage.Center = tapImage.Mnd.Center
 		case fyne.KeyRight:
-			tapImage.Center += complex(offset*math.Abs(real(tapImage.Range)), 0.0)
+			tapImage.Mnd.Pan(complex(offset*math.Abs(real(tapImage.Range)), 0.0))
+			tapImage.Center = tapImage.Mnd.Center
 		case fyne.KeyUp:
-			tapImage.Center += complex(0.0, offset*math.Abs(imag(tapImage.Range)))
+			tapImage.Mnd.Pan(complex(0.0, offset*math.Abs(imag(tapImage.Range))))
+			tapImage.Center = tapImage.Mnd.Center
 		case fyne.KeyDown:
-			tapImage.Center -= complex(0.0, offset*math.Abs(imag(tapImage.Range)))
+			tapImage.Mnd.Pan(-complex(0.0, offset*math.Abs(imag(tapImage.Range))))
+			tapImage.Center = tapImage.Mnd.Center
+
+		case fyne.KeyC: // next coloring mode
+			tapImage.Mnd.ColorMode = (tapImage.Mnd.ColorMode + 1) % mandel.ColorMode(len(mandel.ColorModeNames))
+
+		case fyne.KeyF: // next formula
+			tapImage.nextFormula()
+
+		case fyne.KeyJ: // julia set of the point under the cursor / back to mandelbrot
+			tapImage.julia()
+
+		case fyne.KeyD: // toggle forced deep zoom (perturbation) mode
+			tapImage.Mnd.Deep = !tapImage.Mnd.Deep
 
 		case fyne.KeyPageUp:
 			tapImage.Range = complex(real(tapImage.Range)*2, imag(tapImage.Range)*2)
@@ -156,6 +361,13 @@ func main() {
 
 		case fyne.KeyS:
 			tapImage.save_last()
+			return
+
+		case fyne.KeyL: // save location
+			tapImage.save_location()
+			return
+		case fyne.KeyO: // load next saved location
+			tapImage.load_location()
 
 		default:
 			log.Printf("Typed key: %s", key.Name)
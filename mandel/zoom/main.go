@@ -0,0 +1,40 @@
+// render a keyframed zoom from a locations json file (saved with L in the viewer)
+//
+//	go run ./zoom -keys locations.json -frames 60 -out zoom.gif
+//	go run ./zoom -keys locations.json -w 1920 -h 1080 -out frames/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"mandel/mandel"
+	"math"
+)
+
+func main() {
+	keysFile := flag.String("keys", "locations.json", "keyframe locations json file")
+	framesPerKey := flag.Int("frames", 60, "frames between consecutive keyframes")
+	w := flag.Int("w", 640, "frame width")
+	h := flag.Int("h", 640, "frame height")
+	out := flag.String("out", "frames", "output .gif file or png frames directory")
+	flag.Parse()
+
+	keys, err := mandel.LoadLocations(*keysFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	frames, err := mandel.KeyframeZoom(keys, *framesPerKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = mandel.RenderZoom(*w, *h, frames, *out, func(i int, m *mandel.Mandel) {
+		fmt.Printf("frame %d/%d, range: %.3g, iters: %d, lap: %v ms\r", i+1, len(frames), math.Abs(real(m.Range)), m.Iters, m.Lap)
+	})
+	fmt.Println()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
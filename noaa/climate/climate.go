@@ -0,0 +1,339 @@
+// climate normals, anomalies and calendar day records of station series
+
+package climate
+
+import (
+	"iter"
+	"math"
+	"slices"
+	"time"
+
+	"noaa/noaa"
+
+	"github.com/go-gota/gota/dataframe"
+)
+
+// valid physical daily values of a station element, sorted by date
+type DailySeries struct {
+	Id      string
+	Element string
+	Unit    noaa.Unit
+	Dates   []time.Time
+	Values  []float64
+}
+
+type Key struct {
+	Id      string
+	Element string
+}
+
+// physical series of each station element in seq, reject lists the qflags to skip
+func Collect(reg noaa.Registry, seq iter.Seq2[noaa.DailyRaw, noaa.Station], reject string) map[Key]*DailySeries {
+	series := map[Key]*DailySeries{}
+	for dr := range seq {
+		ms := reg.Decode(&dr, reject)
+		k := Key{ms.Id, ms.Element}
+		s, ok := series[k]
+		if !ok {
+			s = &DailySeries{Id: ms.Id, Element: ms.Element, Unit: ms.Unit}
+			series[k] = s
+		}
+		s.AddMonth(ms)
+	}
+	for _, s := range series {
+		s.sort()
+	}
+	return series
+}
+
+// append the valid days of ms, call sort after adding out of order months
+func (s *DailySeries) AddMonth(ms noaa.MonthSeries) {
+	for d, v := range ms.Values {
+		if !math.IsNaN(v) {
+			s.Dates = append(s.Dates, time.Date(ms.Year, time.Month(ms.Month), d+1, 0, 0, 0, 0, time.UTC))
+			s.Values = append(s.Values, v)
+		}
+	}
+}
+
+func (s *DailySeries) sort() {
+	if slices.IsSortedFunc(s.Dates, time.Time.Compare) {
+		return
+	}
+	idx := make([]int, len(s.Dates))
+	for i := range idx {
+		idx[i] = i
+	}
+	slices.SortStableFunc(idx, func(a, b int) int { return s.Dates[a].Compare(s.Dates[b]) })
+	dates, values := make([]time.Time, len(idx)), make([]float64, len(idx))
+	for i, j := range idx {
+		dates[i], values[i] = s.Dates[j], s.Values[j]
+	}
+	s.Dates, s.Values = dates, values
+}
+
+// days of year, of the last year with data if 0
+func (s *DailySeries) Year(year int) DailySeries {
+	out := DailySeries{Id: s.Id, Element: s.Element, Unit: s.Unit}
+	if year == 0 && len(s.Dates) > 0 {
+		year = s.Dates[len(s.Dates)-1].Year()
+	}
+	lo, _ := slices.BinarySearchFunc(s.Dates, year, func(t time.Time, y int) int { return t.Year() - y })
+	hi, _ := slices.BinarySearchFunc(s.Dates, year+1, func(t time.Time, y int) int { return t.Year() - y })
+	out.Dates, out.Values = s.Dates[lo:hi], s.Values[lo:hi]
+	return out
+}
+
+// calendar day 0..365 of t in a leap year, feb 29 is 59
+func calendarDay(t time.Time) int {
+	return time.Date(2000, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).YearDay() - 1
+}
+
+// month and day of calendar day cd
+func calendarDate(cd int) (int, int) {
+	t := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, cd)
+	return int(t.Month()), t.Day()
+}
+
+// mean of a station-month with its number of valid days
+type MonthValue struct {
+	Id      string
+	Element string
+	Year    int
+	Month   int
+	Value   float64
+	Days    int     // valid days
+	Anomaly float64 // against the normal, NaN without one
+}
+
+// monthly means of months missing at most maxMissing days
+func MonthlyMeans(s *DailySeries, maxMissing int) []MonthValue {
+	var months []MonthValue
+	for i := 0; i < len(s.Dates); {
+		year, month := s.Dates[i].Year(), int(s.Dates[i].Month())
+		sum, days := 0.0, 0
+		for ; i < len(s.Dates) && s.Dates[i].Year() == year && int(s.Dates[i].Month()) == month; i++ {
+			sum += s.Values[i]
+			days++
+		}
+		if noaa.DaysIn(year, month)-days <= maxMissing {
+			months = append(months, MonthValue{Id: s.Id, Element: s.Element, Year: year, Month: month,
+				Value: sum / float64(days), Days: days, Anomaly: math.NaN()})
+		}
+	}
+	return months
+}
+
+type NormalsOptions struct {
+	From, To   int // reference period, 1991-2020 if 0
+	MaxMissing int // missing days for a month to count, 10 if 0 (wmo)
+	MinYears   int // years with a valid month for its normal, 80% of the period if 0
+	Window     int // centered moving average of the daily normals in days, 31 if 0
+}
+
+func (o NormalsOptions) defaults() NormalsOptions {
+	if o.From == 0 {
+		o.From, o.To = 1991, 2020
+	}
+	if o.MaxMissing == 0 {
+		o.MaxMissing = 10
+	}
+	if o.MinYears == 0 {
+		o.MinYears = int(math.Ceil(0.8 * float64(o.To-o.From+1)))
+	}
+	if o.Window == 0 {
+		o.Window = 31
+	}
+	return o
+}
+
+// normals of a station element over a reference period, NaN when not enough data
+type Normals struct {
+	Id       string
+	Element  string
+	From, To int
+	Monthly  [12]float64
+	Years    [12]int      // years contributing to each monthly normal
+	Daily    [366]float64 // smoothed calendar day normals
+	DailyStd [366]float64 // standard deviation of the days in the window of Daily
+}
+
+func ComputeNormals(s *DailySeries, opts NormalsOptions) Normals {
+	opts = opts.defaults()
+	n := Normals{Id: s.Id, Element: s.Element, From: opts.From, To: opts.To}
+
+	var sums [12]float64
+	for _, mv := range MonthlyMeans(s, opts.MaxMissing) {
+		if mv.Year >= opts.From && mv.Year <= opts.To {
+			sums[mv.Month-1] += mv.Value
+			n.Years[mv.Month-1]++
+		}
+	}
+	for m := range n.Monthly {
+		n.Monthly[m] = math.NaN()
+		if n.Years[m] >= opts.MinYears {
+			n.Monthly[m] = sums[m] / float64(n.Years[m])
+		}
+	}
+
+	// raw calendar day means, then a circular moving average
+	var daySum, daySq [366]float64
+	var dayCount [366]int
+	for i, t := range s.Dates {
+		if y := t.Year(); y >= opts.From && y <= opts.To {
+			cd := calendarDay(t)
+			daySum[cd] += s.Values[i]
+			daySq[cd] += s.Values[i] * s.Values[i]
+			dayCount[cd]++
+		}
+	}
+	half := opts.Window / 2
+	for cd := range n.Daily {
+		sum, sq, count := 0.0, 0.0, 0
+		for k := cd - half; k <= cd+half; k++ {
+			j := (k + 366) % 366
+			sum += daySum[j]
+			sq += daySq[j]
+			count += dayCount[j]
+		}
+		n.Daily[cd], n.DailyStd[cd] = math.NaN(), math.NaN()
+		if count >= opts.MinYears*opts.Window/2 { // half the window of the min years
+			mean := sum / float64(count)
+			n.Daily[cd] = mean
+			n.DailyStd[cd] = math.Sqrt(max(0, sq/float64(count)-mean*mean))
+		}
+	}
+	return n
+}
+
+// monthly means with their anomaly against the monthly normals
+func (n Normals) MonthlyAnomalies(s *DailySeries, maxMissing int) []MonthValue {
+	months := MonthlyMeans(s, maxMissing)
+	for i := range months {
+		months[i].Anomaly = months[i].Value - n.Monthly[months[i].Month-1]
+	}
+	return months
+}
+
+// daily values minus the calendar day normal, days without a normal are dropped
+func (n Normals) DailyAnomalies(s *DailySeries) DailySeries {
+	out := DailySeries{Id: s.Id, Element: s.Element, Unit: s.Unit}
+	for i, t := range s.Dates {
+		if normal := n.Daily[calendarDay(t)]; !math.IsNaN(normal) {
+			out.Dates = append(out.Dates, t)
+			out.Values = append(out.Values, s.Values[i]-normal)
+		}
+	}
+	return out
+}
+
+// annual means of years with at least minDays valid days, as trend input
+func AnnualMeans(s *DailySeries, minDays int) (years, values []float64) {
+	for i := 0; i < len(s.Dates); {
+		year := s.Dates[i].Year()
+		sum, days := 0.0, 0
+		for ; i < len(s.Dates) && s.Dates[i].Year() == year; i++ {
+			sum += s.Values[i]
+			days++
+		}
+		if days >= minDays {
+			years = append(years, float64(year))
+			values = append(values, sum/float64(days))
+		}
+	}
+	return years, values
+}
+
+// values minus their mean over the years from..to, over all years when
+// none is in the period
+func Anomalies(years, values []float64, from, to int) []float64 {
+	sum, n := 0.0, 0
+	for i, y := range years {
+		if int(y) >= from && int(y) <= to {
+			sum += values[i]
+			n++
+		}
+	}
+	if n == 0 {
+		for _, v := range values {
+			sum += v
+		}
+		n = len(values)
+	}
+	anomalies := make([]float64, len(values))
+	for i, v := range values {
+		anomalies[i] = v - sum/float64(n)
+	}
+	return anomalies
+}
+
+// record high and low of a calendar day
+type Record struct {
+	Id       string
+	Element  string
+	Month    int
+	Day      int
+	High     float64
+	HighYear int
+	Low      float64
+	LowYear  int
+	Years    int // years with a value
+}
+
+// records per calendar day with data, the earliest year wins ties
+func Records(s *DailySeries) []Record {
+	var byDay [366]*Record
+	for i, t := range s.Dates {
+		cd, v := calendarDay(t), s.Values[i]
+		r := byDay[cd]
+		if r == nil {
+			month, day := calendarDate(cd)
+			r = &Record{Id: s.Id, Element: s.Element, Month: month, Day: day, High: v, HighYear: t.Year(), Low: v, LowYear: t.Year()}
+			byDay[cd] = r
+		}
+		if v > r.High {
+			r.High, r.HighYear = v, t.Year()
+		}
+		if v < r.Low {
+			r.Low, r.LowYear = v, t.Year()
+		}
+		r.Years++
+	}
+
+	var records []Record
+	for _, r := range byDay {
+		if r != nil {
+			records = append(records, *r)
+		}
+	}
+	return records
+}
+
+// data frames
+
+// one row per station-element-month
+type NormalRow struct {
+	Id      string
+	Element string
+	Month   int
+	Normal  float64
+	Years   int
+}
+
+func NormalsFrame(normals []Normals) dataframe.DataFrame {
+	var rows []NormalRow
+	for _, n := range normals {
+		for m, v := range n.Monthly {
+			rows = append(rows, NormalRow{Id: n.Id, Element: n.Element, Month: m + 1, Normal: v, Years: n.Years[m]})
+		}
+	}
+	return dataframe.LoadStructs(rows)
+}
+
+func AnomaliesFrame(months []MonthValue) dataframe.DataFrame {
+	return dataframe.LoadStructs(months)
+}
+
+func RecordsFrame(records []Record) dataframe.DataFrame {
+	return dataframe.LoadStructs(records)
+}
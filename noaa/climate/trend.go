@@ -0,0 +1,124 @@
+// ols and theil-sen trends with confidence intervals
+
+package climate
+
+import (
+	"math"
+	"slices"
+
+	"github.com/go-gota/gota/dataframe"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// y = Intercept + Slope*x, slope in [SlopeLow, SlopeHigh] at Confidence
+type Trend struct {
+	Id         string
+	Element    string
+	Method     string
+	Slope      float64
+	Intercept  float64
+	SlopeLow   float64
+	SlopeHigh  float64
+	Confidence float64
+	N          int
+}
+
+// slope per 10 units of x, per decade for yearly x
+func (t Trend) PerDecade() float64 { return t.Slope * 10 }
+
+// true when the confidence interval excludes a zero slope
+func (t Trend) Significant() bool { return t.SlopeLow > 0 || t.SlopeHigh < 0 }
+
+func (t Trend) At(x float64) float64 { return t.Intercept + t.Slope*x }
+
+// ordinary least squares, student t interval of the slope
+func OLS(x, y []float64, confidence float64) Trend {
+	n := len(x)
+	t := Trend{Method: "ols", Confidence: confidence, N: n,
+		Slope: math.NaN(), Intercept: math.NaN(), SlopeLow: math.NaN(), SlopeHigh: math.NaN()}
+	if n < 2 {
+		return t
+	}
+
+	var mx, my float64
+	for i := range n {
+		mx += x[i]
+		my += y[i]
+	}
+	mx, my = mx/float64(n), my/float64(n)
+
+	var sxx, sxy float64
+	for i := range n {
+		sxx += (x[i] - mx) * (x[i] - mx)
+		sxy += (x[i] - mx) * (y[i] - my)
+	}
+	if sxx == 0 {
+		return t
+	}
+	t.Slope = sxy / sxx
+	t.Intercept = my - t.Slope*mx
+
+	if n < 3 {
+		return t
+	}
+	var sse float64
+	for i := range n {
+		r := y[i] - t.At(x[i])
+		sse += r * r
+	}
+	se := math.Sqrt(sse / float64(n-2) / sxx)
+	q := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: float64(n - 2)}.Quantile(1 - (1-confidence)/2)
+	t.SlopeLow, t.SlopeHigh = t.Slope-q*se, t.Slope+q*se
+	return t
+}
+
+// theil-sen median of pairwise slopes with sen's nonparametric interval,
+// o(n²) pairs so meant for annual or monthly aggregates
+func TheilSen(x, y []float64, confidence float64) Trend {
+	n := len(x)
+	t := Trend{Method: "theil-sen", Confidence: confidence, N: n,
+		Slope: math.NaN(), Intercept: math.NaN(), SlopeLow: math.NaN(), SlopeHigh: math.NaN()}
+
+	slopes := make([]float64, 0, n*(n-1)/2)
+	for i := range n {
+		for j := i + 1; j < n; j++ {
+			if x[j] != x[i] {
+				slopes = append(slopes, (y[j]-y[i])/(x[j]-x[i]))
+			}
+		}
+	}
+	if len(slopes) == 0 {
+		return t
+	}
+	slices.Sort(slopes)
+	t.Slope = median(slopes)
+
+	residuals := make([]float64, n)
+	for i := range n {
+		residuals[i] = y[i] - t.Slope*x[i]
+	}
+	slices.Sort(residuals)
+	t.Intercept = median(residuals)
+
+	// rank bounds of the interval from the variance of kendall's s, no ties correction
+	z := distuv.UnitNormal.Quantile(1 - (1-confidence)/2)
+	c := z * math.Sqrt(float64(n*(n-1)*(2*n+5))/18)
+	m := float64(len(slopes))
+	lo, hi := int(math.Round((m-c)/2)), int(math.Round((m+c)/2))+1 // 1 based ranks
+	if lo >= 1 && hi <= len(slopes) {
+		t.SlopeLow, t.SlopeHigh = slopes[lo-1], slopes[hi-1]
+	}
+	return t
+}
+
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func TrendsFrame(trends []Trend) dataframe.DataFrame {
+	return dataframe.LoadStructs(trends)
+}
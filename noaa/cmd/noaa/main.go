@@ -0,0 +1,560 @@
+// noaa ghcn-daily query & export
+//
+//	noaa stations -near 40.7,-74 -radius 100
+//	noaa extract -element TMAX -country SP -from 2000 -to 2024 -format csv
+//	noaa extract -element TMAX,TMIN -format cache -out cache/
+//	noaa summary -element PRCP -country US -group country
+//	noaa plot -kind climatology -element TMAX -station USW00094728 -out nyc.svg
+//	noaa serve -addr :8080 -cache cache/
+//	noaa update -dir ghcnd_all -diffs downloads/
+//
+// the data dir is $NOAA_DATA_PATH unless -data is given, records stream from
+// the daily tarball or from a column cache with -cache
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"iter"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"noaa/noaa"
+)
+
+const usage = `usage: noaa <command> [flags]
+
+commands:
+  stations   list stations by country, state, radius or nearest
+  extract    daily values as csv, json, ndjson or a column cache
+  summary    monthly aggregates per station, country or all
+  plot       series, annual, climatology, stripes, anomalies or map chart
+  serve      http json/csv api of stations, series and aggregates
+  update     apply superghcnd diff files to a dir of .dly station files
+
+noaa <command> -h for its flags
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "stations":
+		err = stations(args)
+	case "extract":
+		err = extract(ctx, args)
+	case "summary":
+		err = summary(ctx, args)
+	case "plot":
+		err = plotCmd(ctx, args)
+	case "serve":
+		err = serve(ctx, args)
+	case "update":
+		err = update(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// flags shared by the commands
+type common struct {
+	fs      *flag.FlagSet
+	data    string
+	country string
+	state   string
+	station string
+	near    string
+	radius  float64
+	nearest int
+	format  string
+}
+
+func newCommon(name string) *common {
+	c := &common{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	c.fs.StringVar(&c.data, "data", noaa.DataPath(), "data dir")
+	c.fs.StringVar(&c.country, "country", "", "country code or name")
+	c.fs.StringVar(&c.state, "state", "", "us state or canadian province code or name")
+	c.fs.StringVar(&c.station, "station", "", "comma separated station ids")
+	c.fs.StringVar(&c.near, "near", "", "lat,lon of a radius or nearest query")
+	c.fs.Float64Var(&c.radius, "radius", 100, "radius of -near in km")
+	c.fs.IntVar(&c.nearest, "n", 0, "the n nearest stations of -near instead of a radius")
+	c.fs.StringVar(&c.format, "format", "csv", "output format: "+strings.Join(formats, ", "))
+	return c
+}
+
+func (c *common) open() (*noaa.NOAA_DB, error) {
+	return noaa.Open(c.data)
+}
+
+// country code of -country, "" for all
+func (c *common) countryCode(db *noaa.NOAA_DB) (string, error) {
+	if c.country == "" {
+		return "", nil
+	}
+	code, ok := db.CountryCode(c.country)
+	if !ok {
+		return "", fmt.Errorf("unknown country %q", c.country)
+	}
+	return code, nil
+}
+
+// stations selected by the flags with their distance to -near, sorted by id
+// or distance. all is true when no flag restricts the selection
+func (c *common) stations(db *noaa.NOAA_DB) ([]noaa.StationDistance, bool, error) {
+	q := noaa.StationQuery{Country: c.country, State: c.state, Radius: c.radius, Nearest: c.nearest}
+	if c.near != "" {
+		var err error
+		if q.Lat, q.Lon, err = parseLatLon(c.near); err != nil {
+			return nil, false, err
+		}
+		q.Near = true
+	}
+	if c.station != "" {
+		for id := range strings.SplitSeq(c.station, ",") {
+			q.Ids = append(q.Ids, strings.TrimSpace(id))
+		}
+	}
+	selected, err := db.SelectStations(q)
+	all := c.country == "" && c.state == "" && c.near == "" && c.station == ""
+	return selected, all, err
+}
+
+// physical values without the float noise of the scaling, e.g. 343 * 0.1
+func round(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+func parseLatLon(s string) (float64, float64, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if ok {
+		la, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		lo, err2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if err1 == nil && err2 == nil && la >= -90 && la <= 90 && lo >= -180 && lo <= 180 {
+			return la, lo, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid lat,lon %q", s)
+}
+
+// stations
+
+type stationRow struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	State     string   `json:"state,omitempty"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Elevation float64  `json:"elevation"`
+	Distance  *float64 `json:"distance_km,omitempty"`
+}
+
+func (r stationRow) header() []string {
+	return []string{"id", "name", "state", "latitude", "longitude", "elevation", "distance_km"}
+}
+
+func (r stationRow) fields() []string {
+	distance := ""
+	if r.Distance != nil {
+		distance = strconv.FormatFloat(*r.Distance, 'f', 1, 64)
+	}
+	return []string{r.Id, r.Name, r.State, ftoa(r.Latitude), ftoa(r.Longitude), ftoa(r.Elevation), distance}
+}
+
+func stations(args []string) error {
+	c := newCommon("stations")
+	c.fs.Parse(args)
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	selected, _, err := c.stations(db)
+	if err != nil {
+		return err
+	}
+	out, err := newOutput(os.Stdout, c.format)
+	if err != nil {
+		return err
+	}
+	for _, s := range selected {
+		r := stationRow{Id: s.Id, Name: strings.TrimSpace(s.Name), State: strings.TrimSpace(s.State),
+			Latitude: s.Latitude, Longitude: s.Longitude, Elevation: s.Elevation}
+		if c.near != "" {
+			r.Distance = &s.Distance
+		}
+		if err := out.write(r); err != nil {
+			return err
+		}
+	}
+	return out.close()
+}
+
+// record selection of extract and summary
+
+type selection struct {
+	*common
+	element  string
+	from, to int
+	reject   string
+	cache    string
+	progress bool
+}
+
+func newSelection(name string) *selection {
+	s := &selection{common: newCommon(name)}
+	s.fs.StringVar(&s.element, "element", "", "comma separated elements, all if empty")
+	s.fs.IntVar(&s.from, "from", 0, "first year")
+	s.fs.IntVar(&s.to, "to", 0, "last year")
+	s.fs.StringVar(&s.reject, "reject", noaa.AllQflags, "qflags of values to skip, '' keeps flagged values")
+	s.fs.StringVar(&s.cache, "cache", "", "read from the column cache in this dir instead of the tarball, sflags are not cached")
+	s.fs.BoolVar(&s.progress, "progress", false, "report progress on stderr")
+	return s
+}
+
+func (s *selection) elements() []string {
+	var elements []string
+	for e := range strings.SplitSeq(s.element, ",") {
+		if e = strings.ToUpper(strings.TrimSpace(e)); e != "" {
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
+
+// traversal options of the selection, station files are skipped by id
+// before parsing and by the inventory when there is one
+func (s *selection) options(db *noaa.NOAA_DB) (noaa.TraverseOptions, error) {
+	selected, all, err := s.stations(db)
+	if err != nil {
+		return noaa.TraverseOptions{}, err
+	}
+	elements := s.elements()
+
+	opts := noaa.TraverseOptions{Ordered: true}
+	if !all || (db.Inventory != nil && elements != nil) {
+		ids := map[string]bool{}
+		for _, st := range selected {
+			ids[st.Id] = true
+		}
+		if db.Inventory != nil && elements != nil {
+			inventory := map[string]bool{}
+			for _, e := range elements {
+				for _, id := range db.InventoryStations(e, "", s.from, s.to) {
+					inventory[id] = true
+				}
+			}
+			for id := range ids {
+				if !inventory[id] {
+					delete(ids, id)
+				}
+			}
+		}
+		opts.Stations = func(id string) bool { return ids[id] }
+	}
+	opts.Filter = func(dr noaa.DailyRaw, station noaa.Station) bool {
+		year := dr.Year()
+		return (elements == nil || slices.Contains(elements, dr.Element())) &&
+			(s.from == 0 || year >= s.from) && (s.to == 0 || year <= s.to)
+	}
+	if s.progress {
+		start := time.Now()
+		opts.ProgressEvery = 1000
+		opts.Progress = func(p noaa.Progress) {
+			fmt.Fprintf(os.Stderr, "\r%d files, %d records, %s %d, %v  ", p.Files, p.Found, p.Id, p.Year, time.Since(start).Round(time.Second))
+		}
+	}
+	return opts, nil
+}
+
+// selected monthly records from the tarball or the cache, the returned func
+// reports the error that stopped the iteration if any
+func (s *selection) dailies(ctx context.Context, db *noaa.NOAA_DB) (iter.Seq2[noaa.DailyRaw, noaa.Station], func() error, error) {
+	opts, err := s.options(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.cache == "" {
+		seq, errf := db.Dailies(ctx, opts)
+		return seq, errf, nil
+	}
+
+	cache, err := noaa.OpenCache(db, s.cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	elements := s.elements()
+	if elements == nil {
+		if elements, err = cache.Elements(); err != nil {
+			cache.Close()
+			return nil, nil, err
+		}
+	}
+	country, err := s.countryCode(db)
+	if err != nil {
+		cache.Close()
+		return nil, nil, err
+	}
+	var stations []string
+	if opts.Stations != nil {
+		stations = []string{}
+		for id := range db.Stations {
+			if opts.Stations(id) {
+				stations = append(stations, id)
+			}
+		}
+		slices.Sort(stations)
+	}
+
+	// one cache query per element
+	var qerr error
+	seq := func(yield func(noaa.DailyRaw, noaa.Station) bool) {
+		defer cache.Close()
+		for _, e := range elements {
+			cseq, errf := cache.Dailies(noaa.CacheQuery{Element: e, Country: country, Stations: stations, FromYear: s.from, ToYear: s.to})
+			for dr, station := range cseq {
+				if ctx.Err() != nil {
+					qerr = ctx.Err()
+					return
+				}
+				if !yield(dr, station) {
+					return
+				}
+			}
+			if qerr = errf(); qerr != nil {
+				return
+			}
+		}
+	}
+	return seq, func() error { return qerr }, nil
+}
+
+// extract
+
+type dayRow struct {
+	Id      string  `json:"id"`
+	Date    string  `json:"date"`
+	Element string  `json:"element"`
+	Value   float64 `json:"value"`
+	Unit    string  `json:"unit,omitempty"`
+	Mflag   string  `json:"mflag,omitempty"`
+	Qflag   string  `json:"qflag,omitempty"`
+	Sflag   string  `json:"sflag,omitempty"`
+}
+
+func (r dayRow) header() []string {
+	return []string{"id", "date", "element", "value", "unit", "mflag", "qflag", "sflag"}
+}
+
+func (r dayRow) fields() []string {
+	return []string{r.Id, r.Date, r.Element, ftoa(r.Value), r.Unit, r.Mflag, r.Qflag, r.Sflag}
+}
+
+func flagString(b byte) string {
+	return strings.TrimSpace(string(b))
+}
+
+func extract(ctx context.Context, args []string) error {
+	s := newSelection("extract")
+	dir := s.fs.String("out", "", "cache dir of -format cache")
+	raw := s.fs.Bool("raw", false, "raw values in tenths etc instead of physical units")
+	s.fs.Usage = func() {
+		fmt.Fprintln(s.fs.Output(), "usage: noaa extract [flags], -format cache writes a column cache to -out")
+		s.fs.PrintDefaults()
+	}
+	s.fs.Parse(args)
+
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	if s.format == "cache" {
+		if *dir == "" {
+			return fmt.Errorf("-format cache needs -out")
+		}
+		opts, err := s.options(db)
+		if err != nil {
+			return err
+		}
+		return noaa.Ingest(ctx, db, *dir, opts)
+	}
+
+	out, err := newOutput(os.Stdout, s.format)
+	if err != nil {
+		return err
+	}
+	seq, errf, err := s.dailies(ctx, db)
+	if err != nil {
+		return err
+	}
+	for dr := range seq {
+		info := db.Registry.Info(dr.Element())
+		r := dayRow{Id: dr.Id(), Element: dr.Element(), Unit: string(info.Unit)}
+		if *raw {
+			r.Unit = ""
+		}
+		year, month := dr.Year(), dr.Month()
+		for d := range dr.Days() {
+			value, ok := dr.Valid(d, s.reject)
+			if !ok {
+				continue
+			}
+			r.Date = fmt.Sprintf("%04d-%02d-%02d", year, month, d+1)
+			r.Value = float64(value)
+			if !*raw {
+				r.Value = round(db.Registry.Quantity(r.Element, value).Value)
+			}
+			r.Mflag, r.Qflag, r.Sflag = flagString(dr.Mflag(d)), flagString(dr.Qflag(d)), flagString(dr.Sflag(d))
+			if err = out.write(r); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = errf()
+	}
+	if s.progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if cerr := out.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// summary
+
+type summaryRow struct {
+	Group    string  `json:"group"`
+	Element  string  `json:"element"`
+	Year     int     `json:"year"`
+	Month    int     `json:"month"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Mean     float64 `json:"mean"`
+	Sum      float64 `json:"sum"`
+	Count    int     `json:"count"`    // valid station-days
+	Coverage float64 `json:"coverage"` // valid / calendar station-days
+	Unit     string  `json:"unit,omitempty"`
+}
+
+func (r summaryRow) header() []string {
+	return []string{"group", "element", "year", "month", "min", "max", "mean", "sum", "count", "coverage", "unit"}
+}
+
+func (r summaryRow) fields() []string {
+	return []string{r.Group, r.Element, strconv.Itoa(r.Year), strconv.Itoa(r.Month), ftoa(r.Min), ftoa(r.Max),
+		strconv.FormatFloat(r.Mean, 'f', 3, 64), ftoa(r.Sum), strconv.Itoa(r.Count),
+		strconv.FormatFloat(r.Coverage, 'f', 3, 64), r.Unit}
+}
+
+func newSummaryRow(reg noaa.Registry, group, element string, year, month int, st noaa.Stat) summaryRow {
+	q := func(v float64) float64 { return round(v * reg.Info(element).Scale) }
+	return summaryRow{Group: group, Element: element, Year: year, Month: month,
+		Min: q(float64(st.Min)), Max: q(float64(st.Max)), Mean: q(st.Avg()), Sum: q(st.Sum),
+		Count: st.Count, Coverage: st.Coverage(), Unit: string(reg.Info(element).Unit)}
+}
+
+func summary(ctx context.Context, args []string) error {
+	s := newSelection("summary")
+	group := s.fs.String("group", "station", "aggregate by station, country or all")
+	s.fs.Parse(args)
+	if !slices.Contains([]string{"station", "country", "all"}, *group) {
+		return fmt.Errorf("unknown group %q, one of station, country, all", *group)
+	}
+
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	out, err := newOutput(os.Stdout, s.format)
+	if err != nil {
+		return err
+	}
+	seq, errf, err := s.dailies(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	// station months stream out, country and all months are merged first
+	type key struct {
+		group   string
+		element string
+		year    int
+		month   int
+	}
+	merged := map[key]noaa.Stat{}
+	for dr := range seq {
+		st := dr.Stats(s.reject)
+		if *group == "station" {
+			if st.Count == 0 {
+				continue
+			}
+			if err = out.write(newSummaryRow(db.Registry, dr.Id(), dr.Element(), dr.Year(), dr.Month(), st)); err != nil {
+				break
+			}
+			continue
+		}
+		k := key{"all", dr.Element(), dr.Year(), dr.Month()}
+		if *group == "country" {
+			k.group = dr.Country()
+		}
+		merged[k] = merged[k].Merge(st)
+	}
+	if err == nil {
+		err = errf()
+	}
+	if s.progress {
+		fmt.Fprintln(os.Stderr)
+	}
+
+	if err == nil {
+		keys := make([]key, 0, len(merged))
+		for k := range merged {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b key) int {
+			if c := strings.Compare(a.group, b.group); c != 0 {
+				return c
+			}
+			if c := strings.Compare(a.element, b.element); c != 0 {
+				return c
+			}
+			return (a.year*12 + a.month) - (b.year*12 + b.month)
+		})
+		for _, k := range keys {
+			if st := merged[k]; st.Count > 0 {
+				if err = out.write(newSummaryRow(db.Registry, k.group, k.element, k.year, k.month, st)); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if cerr := out.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
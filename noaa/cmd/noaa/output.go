@@ -0,0 +1,89 @@
+// streaming csv, json and ndjson row output
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var formats = []string{"csv", "json", "ndjson"}
+
+// a row with its csv header and fields, json from the struct tags
+type row interface {
+	header() []string
+	fields() []string
+}
+
+type output struct {
+	format string
+	w      *bufio.Writer
+	csv    *csv.Writer
+	rows   int
+}
+
+func newOutput(w io.Writer, format string) (*output, error) {
+	o := &output{format: format, w: bufio.NewWriterSize(w, 1<<16)}
+	switch format {
+	case "csv":
+		o.csv = csv.NewWriter(o.w)
+	case "json", "ndjson":
+	default:
+		return nil, fmt.Errorf("unknown format %q, one of %v", format, formats)
+	}
+	return o, nil
+}
+
+func (o *output) write(r row) error {
+	defer func() { o.rows++ }()
+	switch o.format {
+	case "csv":
+		if o.rows == 0 {
+			if err := o.csv.Write(r.header()); err != nil {
+				return err
+			}
+		}
+		return o.csv.Write(r.fields())
+	default:
+		data, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to encode row: %w", err)
+		}
+		if o.format == "ndjson" {
+			_, err = o.w.Write(append(data, '\n'))
+			return err
+		}
+		if o.rows == 0 {
+			o.w.WriteString("[\n")
+		} else {
+			o.w.WriteString(",\n")
+		}
+		_, err = o.w.Write(data)
+		return err
+	}
+}
+
+// terminate the document and flush
+func (o *output) close() error {
+	switch o.format {
+	case "csv":
+		o.csv.Flush()
+		if err := o.csv.Error(); err != nil {
+			return err
+		}
+	case "json":
+		if o.rows == 0 {
+			o.w.WriteString("[")
+		}
+		o.w.WriteString("\n]\n")
+	}
+	return o.w.Flush()
+}
+
+func ftoa(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,143 @@
+// plot command
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"noaa/climate"
+	"noaa/noaa"
+	"noaa/plot"
+)
+
+var plotKinds = []string{"series", "annual", "climatology", "stripes", "anomalies", "map"}
+
+// first-last years
+func parsePeriod(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if ok {
+		f, err1 := strconv.Atoi(strings.TrimSpace(from))
+		t, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 == nil && err2 == nil && f <= t {
+			return f, t, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid period %q, e.g. 1991-2020", s)
+}
+
+func plotCmd(ctx context.Context, args []string) error {
+	s := newSelection("plot")
+	kind := s.fs.String("kind", "series", "chart: "+strings.Join(plotKinds, ", "))
+	out := s.fs.String("out", "", "png or svg file, <kind>.png if empty")
+	base := s.fs.String("base", "1991-2020", "reference period of normals and anomalies")
+	year := s.fs.Int("year", 0, "year over the climatology, the last one if 0")
+	grid := s.fs.Bool("grid", false, "map: idw gridded field under the stations")
+	s.fs.Parse(args)
+
+	if !slices.Contains(plotKinds, *kind) {
+		return fmt.Errorf("unknown kind %q, one of %v", *kind, plotKinds)
+	}
+	elements := s.elements()
+	if len(elements) != 1 {
+		return fmt.Errorf("plot needs one -element")
+	}
+	element := elements[0]
+	from, to, err := parsePeriod(*base)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = *kind + ".png"
+	}
+
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	seq, errf, err := s.dailies(ctx, db)
+	if err != nil {
+		return err
+	}
+	series := climate.Collect(db.Registry, seq, s.reject)
+	if err := errf(); err != nil {
+		return err
+	}
+	if s.progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if len(series) == 0 {
+		return fmt.Errorf("no %s data selected", element)
+	}
+	unit := string(db.Registry.Info(element).Unit)
+
+	var p plot.Renderable
+	if *kind == "map" {
+		p = stationMap(db, series, element, unit, *grid)
+	} else {
+		if len(series) > 1 {
+			return fmt.Errorf("plot -kind %s needs one station, %d selected", *kind, len(series))
+		}
+		ds := slices.Collect(maps.Values(series))[0]
+		name := fmt.Sprintf("%s %s", ds.Id, strings.TrimSpace(db.Stations[ds.Id].Name))
+		normals := climate.ComputeNormals(ds, climate.NormalsOptions{From: from, To: to})
+
+		switch *kind {
+		case "series":
+			p = plot.TimeSeries(name, ds, 0)
+		case "annual":
+			years, values := climate.AnnualMeans(ds, 300)
+			trend := climate.OLS(years, values, 0.95)
+			p = plot.Annual(name+" annual mean", element+" "+unit, years, values, &trend)
+		case "climatology":
+			overlay := ds.Year(*year)
+			p = plot.Climatology(name, normals, unit, &overlay)
+		case "stripes":
+			years, values := climate.AnnualMeans(ds, 300)
+			stripes := plot.NewStripes(years, climate.Anomalies(years, values, from, to))
+			stripes.Label = fmt.Sprintf("%s %s", name, element)
+			p = stripes
+		case "anomalies":
+			months := normals.MonthlyAnomalies(ds, 10)
+			dates, anomalies := make([]time.Time, len(months)), make([]float64, len(months))
+			for i, mv := range months {
+				dates[i] = time.Date(mv.Year, time.Month(mv.Month), 15, 0, 0, 0, 0, time.UTC)
+				anomalies[i] = mv.Anomaly
+			}
+			p = plot.AnomalyBars(fmt.Sprintf("%s monthly anomaly vs %s", name, *base), element+" "+unit, dates, anomalies)
+		}
+	}
+	if err := plot.Save(p, *out); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, *out)
+	return nil
+}
+
+// mean of the selected days per station, gridded by idw with -grid
+func stationMap(db *noaa.NOAA_DB, series map[climate.Key]*climate.DailySeries, element, unit string, gridded bool) plot.StationMap {
+	m := plot.StationMap{Title: element + " mean", Unit: unit}
+	for _, k := range slices.SortedFunc(maps.Keys(series), func(a, b climate.Key) int { return strings.Compare(a.Id, b.Id) }) {
+		ds := series[k]
+		sum := 0.0
+		for _, v := range ds.Values {
+			sum += v
+		}
+		st := db.Stations[k.Id]
+		m.Points = append(m.Points, noaa.GridPoint{Latitude: st.Latitude, Longitude: st.Longitude,
+			Value: sum / float64(len(ds.Values))})
+	}
+	m.Points = slices.DeleteFunc(m.Points, func(p noaa.GridPoint) bool { return math.IsNaN(p.Value) })
+	if gridded {
+		m.Grid = noaa.NewGrid(-90, -180, 90, 180, 360, 180)
+		m.Grid.IDW(m.Points, noaa.IDWOptions{Radius: 1500})
+	}
+	return m
+}
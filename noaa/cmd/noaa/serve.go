@@ -0,0 +1,53 @@
+// serve command
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"noaa/noaa"
+	"noaa/server"
+)
+
+func serve(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	data := fs.String("data", noaa.DataPath(), "data dir")
+	addr := fs.String("addr", ":8080", "listen address")
+	cacheDir := fs.String("cache", "", "column cache dir for series and aggregates, see extract -format cache")
+	pageSize := fs.Int("page", 100, "default page size")
+	fs.Parse(args)
+
+	db, err := noaa.Open(*data)
+	if err != nil {
+		return err
+	}
+	opts := server.Options{PageSize: *pageSize}
+	if *cacheDir != "" {
+		if opts.Cache, err = noaa.OpenCache(db, *cacheDir); err != nil {
+			return err
+		}
+		defer opts.Cache.Close()
+	}
+	handler, err := server.New(db, opts)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	log.Printf("serving %d stations on %s", len(db.Stations), *addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
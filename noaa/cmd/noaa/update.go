@@ -0,0 +1,39 @@
+// update command
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"noaa/noaa"
+)
+
+func update(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	dir := fs.String("dir", "", "dir of .dly station files to update")
+	diffs := fs.String("diffs", "", "dir of superghcnd_diff_*.tar.gz files, or give the files as arguments")
+	gap := fs.Bool("gap", false, "apply diffs not starting at the last applied date")
+	dryRun := fs.Bool("n", false, "check and count the changes without writing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: noaa update -dir <dly dir> [flags] [superghcnd_diff_*.tar.gz ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dir == "" {
+		return fmt.Errorf("update needs -dir")
+	}
+
+	files := fs.Args()
+	if *diffs != "" {
+		found, err := noaa.DiffFiles(*diffs)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	res, err := noaa.ApplyDiffs(*dir, files, noaa.UpdateOptions{AllowGap: *gap, DryRun: *dryRun})
+	fmt.Printf("applied %d, skipped %d diffs: %d stations, %d inserted, %d updated, %d deleted, %d missing, current to %s\n",
+		len(res.Applied), len(res.Skipped), res.Stations, res.Inserted, res.Updated, res.Deleted, res.Missing, res.Current)
+	return err
+}
@@ -3,10 +3,13 @@ module noaa
 go 1.25.0
 
 require (
-	github.com/go-gota/gota v0.12.0 // indirect
+	github.com/go-gota/gota v0.12.0
+	github.com/wcharczuk/go-chart/v2 v2.1.2
+	gonum.org/v1/gonum v0.16.0
+)
+
+require (
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	github.com/wcharczuk/go-chart/v2 v2.1.2 // indirect
 	golang.org/x/image v0.25.0 // indirect
 	golang.org/x/net v0.43.0 // indirect
-	gonum.org/v1/gonum v0.16.0 // indirect
 )
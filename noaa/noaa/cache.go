@@ -0,0 +1,567 @@
+// columnar on-disk cache: dailies ingested once into per element files of
+// station-year partitions with min/max statistics and a partition index
+//
+//	<dir>/<ELEMENT>.col  encoded partitions
+//	<dir>/<ELEMENT>.idx  gob stream of Partition
+
+package noaa
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	partitionDays = 12 * 31 // month*31 + day slots, days not in the month are never present
+	missing       = -9999
+)
+
+// station-year of an element in the cache
+type Partition struct {
+	Element string
+	Station string
+	Year    int
+	Offset  int64 // in the element .col file
+	Length  int32
+	Count   int32 // present days
+	Min     int32 // of present days
+	Max     int32
+}
+
+type Cache struct {
+	dir string
+	db  *NOAA_DB
+
+	mu       sync.Mutex
+	elements map[string]*cacheElement // loaded on first query
+}
+
+type cacheElement struct {
+	file    *os.File
+	parts   []Partition // sorted by station, year
+	version string      // of the .idx and .col files it was loaded from
+	users   int         // queries reading file
+	stale   bool        // replaced by a newer version, closed by its last user
+}
+
+// a station-year being built, one record per present or flagged day
+type partitionBuilder struct {
+	values [partitionDays]int32
+	mflags [partitionDays]byte
+	qflags [partitionDays]byte
+}
+
+func newPartitionBuilder() *partitionBuilder {
+	pb := &partitionBuilder{}
+	for i := range pb.values {
+		pb.values[i], pb.mflags[i], pb.qflags[i] = missing, ' ', ' '
+	}
+	return pb
+}
+
+func (pb *partitionBuilder) add(dr *DailyRaw) {
+	month := dr.Month()
+	if month < 1 || month > 12 {
+		return
+	}
+	for d := range 31 {
+		value, err := strconv.Atoi(strings.TrimSpace(string(dr.items[d].value[:])))
+		if err != nil {
+			value = missing
+		}
+		slot := (month-1)*31 + d
+		pb.values[slot] = int32(value)
+		pb.mflags[slot], pb.qflags[slot] = dr.Mflag(d), dr.Qflag(d)
+	}
+}
+
+// presence bitmap, zigzag varint deltas of present values, then
+// uvarint count of (slot, mflag, qflag) for non blank flags
+func (pb *partitionBuilder) encode(p *Partition) []byte {
+	buf := make([]byte, (partitionDays+7)/8, 256)
+	p.Count, p.Min, p.Max = 0, 0, 0
+
+	prev := int32(0)
+	for slot, v := range pb.values {
+		if v == missing {
+			continue
+		}
+		buf[slot/8] |= 1 << (slot % 8)
+		buf = binary.AppendVarint(buf, int64(v-prev))
+		prev = v
+
+		if p.Count == 0 || v < p.Min {
+			p.Min = v
+		}
+		if p.Count == 0 || v > p.Max {
+			p.Max = v
+		}
+		p.Count++
+	}
+
+	var flagged []int
+	for slot := range pb.values {
+		if pb.mflags[slot] != ' ' || pb.qflags[slot] != ' ' {
+			flagged = append(flagged, slot)
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(flagged)))
+	for _, slot := range flagged {
+		buf = binary.AppendUvarint(buf, uint64(slot))
+		buf = append(buf, pb.mflags[slot], pb.qflags[slot])
+	}
+	p.Length = int32(len(buf))
+	return buf
+}
+
+func decodePartition(data []byte) (*partitionBuilder, error) {
+	bad := errors.New("corrupt partition")
+	pb := newPartitionBuilder()
+	bitmap := (partitionDays + 7) / 8
+	if len(data) < bitmap {
+		return nil, bad
+	}
+	pos := bitmap
+
+	prev := int32(0)
+	for slot := range pb.values {
+		if data[slot/8]&(1<<(slot%8)) == 0 {
+			continue
+		}
+		delta, n := binary.Varint(data[pos:])
+		if n <= 0 {
+			return nil, bad
+		}
+		pos += n
+		prev += int32(delta)
+		pb.values[slot] = prev
+	}
+
+	count, n := binary.Uvarint(data[pos:])
+	if n <= 0 {
+		return nil, bad
+	}
+	pos += n
+	for range count {
+		slot, n := binary.Uvarint(data[pos:])
+		if n <= 0 || slot >= partitionDays || pos+n+2 > len(data) {
+			return nil, bad
+		}
+		pos += n
+		pb.mflags[slot], pb.qflags[slot] = data[pos], data[pos+1]
+		pos += 2
+	}
+	return pb, nil
+}
+
+// monthly records with any present or flagged day, sflag is not kept
+func (pb *partitionBuilder) dailies(p Partition) []DailyRaw {
+	var records []DailyRaw
+	year := fmt.Sprintf("%04d", p.Year)
+	for month := range 12 {
+		slots := pb.values[month*31 : month*31+31]
+		if !slices.ContainsFunc(slots, func(v int32) bool { return v != missing }) &&
+			!slices.ContainsFunc(pb.qflags[month*31:month*31+31], func(q byte) bool { return q != ' ' }) {
+			continue
+		}
+		dr := newDailyRaw(p.Station, year, fmt.Sprintf("%02d", month+1), p.Element)
+		for d, v := range slots {
+			slot := month*31 + d
+			dr.setItem(d, int(v), pb.mflags[slot], pb.qflags[slot], ' ')
+		}
+		records = append(records, dr)
+	}
+	return records
+}
+
+// per element .col and .idx writers of the ingest
+type cacheWriter struct {
+	dir   string
+	files map[string]*elementWriter
+}
+
+type elementWriter struct {
+	col, idx *os.File // temp files, renamed over the element's files when the ingest succeeds
+	colw     *bufio.Writer
+	idxw     *bufio.Writer
+	enc      *gob.Encoder
+	offset   int64
+}
+
+func (cw *cacheWriter) write(p Partition, pb *partitionBuilder) error {
+	ew, ok := cw.files[p.Element]
+	if !ok {
+		col, err := cw.create(p.Element + ".col")
+		if err != nil {
+			return fmt.Errorf("failed to create cache file: %w", err)
+		}
+		idx, err := cw.create(p.Element + ".idx")
+		if err != nil {
+			col.Close()
+			os.Remove(col.Name())
+			return fmt.Errorf("failed to create cache index: %w", err)
+		}
+		ew = &elementWriter{col: col, idx: idx, colw: bufio.NewWriter(col), idxw: bufio.NewWriter(idx)}
+		ew.enc = gob.NewEncoder(ew.idxw)
+		cw.files[p.Element] = ew
+	}
+
+	data := pb.encode(&p)
+	p.Offset = ew.offset
+	if _, err := ew.colw.Write(data); err != nil {
+		return fmt.Errorf("failed to write cache file %s: %w", p.Element, err)
+	}
+	ew.offset += int64(len(data))
+	if err := ew.enc.Encode(p); err != nil {
+		return fmt.Errorf("failed to write cache index %s: %w", p.Element, err)
+	}
+	return nil
+}
+
+// temp file for name in the cache dir, readable like os.Create's
+func (cw *cacheWriter) create(name string) (*os.File, error) {
+	f, err := os.CreateTemp(cw.dir, name+".*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// flush and close the temp files, then rename them into place if commit or
+// remove them. open caches keep reading the files they opened
+func (cw *cacheWriter) close(commit bool) error {
+	var errs []error
+	for _, ew := range cw.files {
+		errs = append(errs, ew.colw.Flush(), ew.idxw.Flush(), ew.col.Close(), ew.idx.Close())
+	}
+	commit = commit && errors.Join(errs...) == nil
+	for element, ew := range cw.files {
+		for _, f := range []struct{ tmp, ext string }{{ew.col.Name(), ".col"}, {ew.idx.Name(), ".idx"}} {
+			if !commit {
+				os.Remove(f.tmp)
+			} else if err := os.Rename(f.tmp, filepath.Join(cw.dir, element+f.ext)); err != nil {
+				errs = append(errs, fmt.Errorf("failed to rename cache file: %w", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// read the dailies selected by opts once and write them to the cache in dir
+func Ingest(ctx context.Context, db *NOAA_DB, dir string, opts TraverseOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache dir %s: %w", dir, err)
+	}
+	cw := &cacheWriter{dir: dir, files: map[string]*elementWriter{}}
+
+	type key struct {
+		element string
+		year    int
+	}
+	station := ""
+	parts := map[key]*partitionBuilder{}
+
+	// write the partitions of the current station, records of a station file are contiguous
+	flush := func() error {
+		keys := slices.SortedFunc(maps.Keys(parts), func(a, b key) int {
+			if c := strings.Compare(a.element, b.element); c != 0 {
+				return c
+			}
+			return a.year - b.year
+		})
+		for _, k := range keys {
+			if err := cw.write(Partition{Element: k.element, Station: station, Year: k.year}, parts[k]); err != nil {
+				return err
+			}
+		}
+		clear(parts)
+		return nil
+	}
+
+	var err error
+	seq, errf := db.Dailies(ctx, opts)
+	for dr := range seq {
+		if id := dr.Id(); id != station {
+			if err = flush(); err != nil {
+				break
+			}
+			station = id
+		}
+		k := key{dr.Element(), dr.Year()}
+		pb, ok := parts[k]
+		if !ok {
+			pb = newPartitionBuilder()
+			parts[k] = pb
+		}
+		pb.add(&dr)
+	}
+	if err == nil {
+		err = errf()
+	}
+	if err == nil {
+		err = flush()
+	}
+	return errors.Join(err, cw.close(err == nil))
+}
+
+func OpenCache(db *NOAA_DB, dir string) (*Cache, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("cache %s is not a directory", dir)
+	}
+	return &Cache{dir: dir, db: db, elements: map[string]*cacheElement{}}, nil
+}
+
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for _, ce := range c.elements {
+		errs = append(errs, ce.file.Close())
+	}
+	clear(c.elements)
+	return errors.Join(errs...)
+}
+
+// hash of the names, sizes and mod times of the .idx and .col files of element
+func (c *Cache) elementVersion(element string) (string, error) {
+	h := fnv.New64a()
+	add := statHasher(os.DirFS(c.dir), h)
+	for _, ext := range []string{".idx", ".col"} {
+		if err := add(element + ext); err != nil {
+			return "", err
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 36), nil
+}
+
+// hash of the names, sizes and mod times of the cache files, changes when
+// elements are ingested again
+func (c *Cache) Version() (string, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cache %s: %w", c.dir, err)
+	}
+	h := fnv.New64a()
+	add := statHasher(os.DirFS(c.dir), h)
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); ext == ".idx" || ext == ".col" {
+			if err := add(e.Name()); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 36), nil
+}
+
+// cached elements
+func (c *Cache) Elements() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(c.dir, "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(filepath.Base(name), ".idx")
+	}
+	return names, nil
+}
+
+// the loaded element, loaded again when its files changed since. the caller
+// reads its file until release
+func (c *Cache) acquire(element string) (*cacheElement, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	version, err := c.elementVersion(element)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache element %s: %w", element, err)
+	}
+	ce, ok := c.elements[element]
+	if !ok || ce.version != version {
+		if ok {
+			delete(c.elements, element)
+			ce.stale = true
+			if ce.users == 0 {
+				ce.file.Close()
+			}
+		}
+		if ce, err = c.load(element, version); err != nil {
+			return nil, err
+		}
+		c.elements[element] = ce
+	}
+	ce.users++
+	return ce, nil
+}
+
+func (c *Cache) release(ce *cacheElement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ce.users--
+	if ce.stale && ce.users == 0 {
+		ce.file.Close()
+	}
+}
+
+// index and open .col file of element, retried when an ingest renamed the
+// files in between so that both are of version
+func (c *Cache) load(element, version string) (*cacheElement, error) {
+	for {
+		ce, err := c.loadOnce(element)
+		if err != nil {
+			return nil, err
+		}
+		ce.version, err = c.elementVersion(element)
+		if err != nil {
+			ce.file.Close()
+			return nil, fmt.Errorf("failed to open cache element %s: %w", element, err)
+		}
+		if ce.version == version {
+			return ce, nil
+		}
+		ce.file.Close()
+		version = ce.version
+	}
+}
+
+func (c *Cache) loadOnce(element string) (*cacheElement, error) {
+	name := filepath.Join(c.dir, element+".idx")
+	idx, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache index %s: %w", name, err)
+	}
+	defer idx.Close()
+
+	ce := &cacheElement{}
+	dec := gob.NewDecoder(bufio.NewReader(idx))
+	for {
+		var p Partition
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read cache index %s: %w", name, err)
+		}
+		ce.parts = append(ce.parts, p)
+	}
+	slices.SortFunc(ce.parts, comparePartition)
+
+	if ce.file, err = os.Open(filepath.Join(c.dir, element+".col")); err != nil {
+		return nil, fmt.Errorf("failed to open cache file: %w", err)
+	}
+	return ce, nil
+}
+
+func comparePartition(a, b Partition) int {
+	if c := strings.Compare(a.Station, b.Station); c != 0 {
+		return c
+	}
+	return a.Year - b.Year
+}
+
+type CacheQuery struct {
+	Element          string
+	Country          string               // station id prefix, "" for all
+	Stations         []string             // station ids, nil for all
+	FromYear, ToYear int                  // 0 for an open bound
+	Where            func(Partition) bool // prunes partitions by their statistics, nil for all
+}
+
+// partitions matching q from the index only
+func (c *Cache) Partitions(q CacheQuery) ([]Partition, error) {
+	ce, err := c.acquire(q.Element)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(ce)
+	return ce.partitions(q), nil
+}
+
+func (ce *cacheElement) partitions(q CacheQuery) []Partition {
+	match := func(p Partition) bool {
+		return (q.FromYear == 0 || p.Year >= q.FromYear) && (q.ToYear == 0 || p.Year <= q.ToYear) &&
+			(q.Where == nil || q.Where(p))
+	}
+	// index range of the partitions of stations with prefix
+	span := func(prefix string) []Partition {
+		lo, _ := slices.BinarySearchFunc(ce.parts, prefix, func(p Partition, s string) int { return strings.Compare(p.Station, s) })
+		hi := lo
+		for hi < len(ce.parts) && strings.HasPrefix(ce.parts[hi].Station, prefix) {
+			hi++
+		}
+		return ce.parts[lo:hi]
+	}
+
+	var parts []Partition
+	add := func(ps []Partition) {
+		for _, p := range ps {
+			if match(p) {
+				parts = append(parts, p)
+			}
+		}
+	}
+	if q.Stations != nil {
+		for _, id := range q.Stations {
+			if strings.HasPrefix(id, q.Country) {
+				add(span(id))
+			}
+		}
+	} else {
+		add(span(q.Country))
+	}
+	return parts
+}
+
+// monthly records of the partitions matching q and their stations,
+// the returned func reports the error that stopped the iteration if any
+func (c *Cache) Dailies(q CacheQuery) (iter.Seq2[DailyRaw, Station], func() error) {
+	var err error
+	seq := func(yield func(DailyRaw, Station) bool) {
+		var ce *cacheElement
+		if ce, err = c.acquire(q.Element); err != nil {
+			return
+		}
+		defer c.release(ce)
+
+		var buf []byte
+		for _, p := range ce.partitions(q) {
+			buf = slices.Grow(buf[:0], int(p.Length))[:p.Length]
+			if _, err = ce.file.ReadAt(buf, p.Offset); err != nil {
+				err = fmt.Errorf("failed to read cache partition %s %s %d: %w", p.Element, p.Station, p.Year, err)
+				return
+			}
+			var pb *partitionBuilder
+			if pb, err = decodePartition(buf); err != nil {
+				err = fmt.Errorf("cache partition %s %s %d: %w", p.Element, p.Station, p.Year, err)
+				return
+			}
+			station := c.db.Stations[p.Station]
+			for _, dr := range pb.dailies(p) {
+				if !yield(dr, station) {
+					return
+				}
+			}
+		}
+	}
+	return seq, func() error { return err }
+}
@@ -0,0 +1,307 @@
+// element registry: scale and unit of each element, physical values,
+// unit conversions and derived variables
+
+package noaa
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+type Unit string
+
+const (
+	None           Unit = ""
+	Celsius        Unit = "°C"
+	Fahrenheit     Unit = "°F"
+	Kelvin         Unit = "K"
+	Millimeter     Unit = "mm"
+	Centimeter     Unit = "cm"
+	Inch           Unit = "in"
+	MeterPerSecond Unit = "m/s"
+	KmPerHour      Unit = "km/h"
+	MilePerHour    Unit = "mph"
+	Knot           Unit = "kn"
+	Degree         Unit = "deg"
+	Percent        Unit = "%"
+	Minute         Unit = "min"
+	Day            Unit = "days"
+	DegreeDay      Unit = "°C·day"
+)
+
+type ElementInfo struct {
+	Code        string
+	Description string
+	Scale       float64 // physical value = raw * Scale
+	Unit        Unit
+}
+
+// element code -> info
+type Registry map[string]ElementInfo
+
+var unitPattern = regexp.MustCompile(`\((tenths of |hundredths of )?([^()]*)\)\s*$`)
+
+var unitNames = map[string]Unit{
+	"degrees c":         Celsius,
+	"degrees f":         Fahrenheit,
+	"mm":                Millimeter,
+	"cm":                Centimeter,
+	"inches":            Inch,
+	"meters per second": MeterPerSecond,
+	"km per hour":       KmPerHour,
+	"degrees":           Degree,
+	"percent":           Percent,
+	"minutes":           Minute,
+	"days":              Day,
+}
+
+// elements without a unit in their description, by code prefix, first match
+// wins: SNOW and SNWD before the SN* soil temperatures
+var knownUnits = []struct {
+	prefix string
+	scale  float64
+	unit   Unit
+}{
+	{"SNOW", 1, Millimeter}, {"SNWD", 1, Millimeter},
+	{"TMAX", 0.1, Celsius}, {"TMIN", 0.1, Celsius}, {"TAVG", 0.1, Celsius}, {"TOBS", 0.1, Celsius},
+	{"MNPN", 0.1, Celsius}, {"MXPN", 0.1, Celsius}, {"SN", 0.1, Celsius}, {"SX", 0.1, Celsius},
+	{"PRCP", 0.1, Millimeter}, {"EVAP", 0.1, Millimeter}, {"MDPR", 0.1, Millimeter},
+	{"WESD", 0.1, Millimeter}, {"WESF", 0.1, Millimeter}, {"THIC", 0.1, Millimeter},
+	{"AWND", 0.1, MeterPerSecond}, {"WSF", 0.1, MeterPerSecond},
+	{"WDF", 1, Degree}, {"AWDR", 1, Degree},
+	{"PSUN", 1, Percent}, {"TSUN", 1, Minute},
+	{"DAPR", 1, Day}, {"DWPR", 1, Day},
+}
+
+// registry from the elements aux file descriptions, "(tenths of mm)" etc,
+// falling back to the known units of the ghcnd readme
+func NewRegistry(elements map[string]string) Registry {
+	r := Registry{}
+	for code, descr := range elements {
+		info := ElementInfo{Code: code, Description: descr, Scale: 1, Unit: None}
+
+		known := false
+		if match := unitPattern.FindStringSubmatch(descr); match != nil {
+			if unit, ok := unitNames[strings.ToLower(strings.TrimSpace(match[2]))]; ok {
+				info.Unit, known = unit, true
+				switch match[1] {
+				case "tenths of ":
+					info.Scale = 0.1
+				case "hundredths of ":
+					info.Scale = 0.01
+				}
+			}
+		}
+		if !known {
+			for _, k := range knownUnits {
+				if strings.HasPrefix(code, k.prefix) {
+					info.Scale, info.Unit = k.scale, k.unit
+					break
+				}
+			}
+		}
+		r[code] = info
+	}
+	return r
+}
+
+// element info, unknown elements are unscaled without unit
+func (r Registry) Info(element string) ElementInfo {
+	if info, ok := r[element]; ok {
+		return info
+	}
+	return ElementInfo{Code: element, Scale: 1, Unit: None}
+}
+
+// physical value with unit
+type Quantity struct {
+	Value float64
+	Unit  Unit
+}
+
+func (q Quantity) String() string {
+	if q.Unit == None {
+		return fmt.Sprintf("%g", q.Value)
+	}
+	return fmt.Sprintf("%g %s", q.Value, q.Unit)
+}
+
+func (q Quantity) In(unit Unit) (Quantity, error) {
+	v, err := Convert(q.Value, q.Unit, unit)
+	return Quantity{v, unit}, err
+}
+
+// raw value of element as a quantity
+func (r Registry) Quantity(element string, raw int) Quantity {
+	info := r.Info(element)
+	return Quantity{float64(raw) * info.Scale, info.Unit}
+}
+
+// to si base-ish units: °C, mm, m/s
+var toBase = map[Unit]struct {
+	base      Unit
+	mul, plus float64 // base = v*mul + plus
+}{
+	Celsius:        {Celsius, 1, 0},
+	Fahrenheit:     {Celsius, 5.0 / 9, -32 * 5.0 / 9},
+	Kelvin:         {Celsius, 1, -273.15},
+	Millimeter:     {Millimeter, 1, 0},
+	Centimeter:     {Millimeter, 10, 0},
+	Inch:           {Millimeter, 25.4, 0},
+	MeterPerSecond: {MeterPerSecond, 1, 0},
+	KmPerHour:      {MeterPerSecond, 1 / 3.6, 0},
+	MilePerHour:    {MeterPerSecond, 0.44704, 0},
+	Knot:           {MeterPerSecond, 1852.0 / 3600, 0},
+}
+
+// convert v between units of the same dimension
+func Convert(v float64, from, to Unit) (float64, error) {
+	if from == to {
+		return v, nil
+	}
+	f, okf := toBase[from]
+	t, okt := toBase[to]
+	if !okf || !okt || f.base != t.base {
+		return math.NaN(), fmt.Errorf("can't convert %q to %q", from, to)
+	}
+	return (v*f.mul + f.plus - t.plus) / t.mul, nil
+}
+
+// physical daily values of a month, NaN for missing or rejected days
+type MonthSeries struct {
+	Id      string
+	Element string
+	Year    int
+	Month   int
+	Unit    Unit
+	Values  []float64 // one per calendar day
+}
+
+// physical values of dr, reject lists the qflags to skip
+func (r Registry) Decode(dr *DailyRaw, reject string) MonthSeries {
+	info := r.Info(dr.Element())
+	ms := MonthSeries{Id: dr.Id(), Element: dr.Element(), Year: dr.Year(), Month: dr.Month(), Unit: info.Unit,
+		Values: make([]float64, dr.Days())}
+	for d := range ms.Values {
+		if value, ok := dr.Valid(d, reject); ok {
+			ms.Values[d] = float64(value) * info.Scale
+		} else {
+			ms.Values[d] = math.NaN()
+		}
+	}
+	return ms
+}
+
+func (ms MonthSeries) In(unit Unit) (MonthSeries, error) {
+	out := ms
+	out.Unit = unit
+	out.Values = make([]float64, len(ms.Values))
+	for d, v := range ms.Values {
+		c, err := Convert(v, ms.Unit, unit)
+		if err != nil {
+			return ms, err
+		}
+		out.Values[d] = c
+	}
+	return out, nil
+}
+
+// sum and count of the valid days
+func (ms MonthSeries) Sum() (float64, int) {
+	sum, count := 0.0, 0
+	for _, v := range ms.Values {
+		if !math.IsNaN(v) {
+			sum += v
+			count++
+		}
+	}
+	return sum, count
+}
+
+// derived variables
+
+const (
+	DegreeDayBase = 18.3 // °C, 65 °F for heating & cooling degree days
+	GrowingBase   = 10.0 // °C
+	GrowingCap    = 30.0 // °C, modified growing degree days
+)
+
+// day by day combination of a and b, NaN when either is NaN
+func combine(element string, unit Unit, a, b MonthSeries, f func(x, y float64) float64) MonthSeries {
+	out := MonthSeries{Id: a.Id, Element: element, Year: a.Year, Month: a.Month, Unit: unit,
+		Values: make([]float64, len(a.Values))}
+	for d := range out.Values {
+		if d < len(b.Values) {
+			out.Values[d] = f(a.Values[d], b.Values[d])
+		} else {
+			out.Values[d] = math.NaN()
+		}
+	}
+	return out
+}
+
+// TAVG where reported, (TMAX+TMIN)/2 on the other days. tavg may be nil
+func Tavg(tmax, tmin MonthSeries, tavg *MonthSeries) MonthSeries {
+	out := combine("TAVG", Celsius, tmax, tmin, func(x, y float64) float64 { return (x + y) / 2 })
+	if tavg != nil {
+		for d, v := range tavg.Values {
+			if d < len(out.Values) && !math.IsNaN(v) {
+				out.Values[d] = v
+			}
+		}
+	}
+	return out
+}
+
+// diurnal temperature range TMAX-TMIN
+func DiurnalRange(tmax, tmin MonthSeries) MonthSeries {
+	return combine("DTR", Celsius, tmax, tmin, func(x, y float64) float64 { return x - y })
+}
+
+// growing degree days, TMAX capped at ceiling and TMIN raised to base, ceiling <= 0 for none
+func GrowingDegreeDays(tmax, tmin MonthSeries, base, ceiling float64) MonthSeries {
+	return combine("GDD", DegreeDay, tmax, tmin, func(x, y float64) float64 {
+		if ceiling > 0 {
+			x = min(x, ceiling)
+		}
+		x, y = max(x, base), max(y, base)
+		return max(0, (x+y)/2-base)
+	})
+}
+
+func HeatingDegreeDays(tavg MonthSeries, base float64) MonthSeries {
+	return combine("HDD", DegreeDay, tavg, tavg, func(x, _ float64) float64 { return max(0, base-x) })
+}
+
+func CoolingDegreeDays(tavg MonthSeries, base float64) MonthSeries {
+	return combine("CDD", DegreeDay, tavg, tavg, func(x, _ float64) float64 { return max(0, x-base) })
+}
+
+// decode the records of one station-month and add TAVG (if not reported),
+// DTR, GDD, HDD and CDD when TMAX and TMIN are present
+func (r Registry) DeriveMonth(records []DailyRaw, reject string) map[string]MonthSeries {
+	series := map[string]MonthSeries{}
+	for i := range records {
+		ms := r.Decode(&records[i], reject)
+		series[ms.Element] = ms
+	}
+
+	tmax, okx := series["TMAX"]
+	tmin, okn := series["TMIN"]
+	if !okx || !okn || tmax.Unit != Celsius || tmin.Unit != Celsius {
+		return series
+	}
+	var reported *MonthSeries
+	if ms, ok := series["TAVG"]; ok && ms.Unit == Celsius {
+		reported = &ms
+	}
+	tavg := Tavg(tmax, tmin, reported)
+	series["TAVG"] = tavg
+	series["DTR"] = DiurnalRange(tmax, tmin)
+	series["GDD"] = GrowingDegreeDays(tmax, tmin, GrowingBase, GrowingCap)
+	series["HDD"] = HeatingDegreeDays(tavg, DegreeDayBase)
+	series["CDD"] = CoolingDegreeDays(tavg, DegreeDayBase)
+	return series
+}
@@ -0,0 +1,180 @@
+// inverse distance weighted gridding of station values onto a lat/lon raster,
+// written as png or as an esri float grid (.flt + .hdr)
+
+package noaa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+)
+
+// value at a position, e.g. a station statistic
+type GridPoint struct {
+	Latitude  float64
+	Longitude float64
+	Value     float64
+}
+
+// lat/lon raster, row 0 is the north edge, NaN cells have no data
+type Grid struct {
+	MinLat, MinLon float64
+	MaxLat, MaxLon float64
+	W, H           int
+	Values         []float64
+}
+
+func NewGrid(minLat, minLon, maxLat, maxLon float64, w, h int) *Grid {
+	g := &Grid{MinLat: minLat, MinLon: minLon, MaxLat: maxLat, MaxLon: maxLon, W: w, H: h, Values: make([]float64, w*h)}
+	for i := range g.Values {
+		g.Values[i] = math.NaN()
+	}
+	return g
+}
+
+// center of cell x, y
+func (g *Grid) Cell(x, y int) (lat, lon float64) {
+	lat = g.MaxLat - (float64(y)+0.5)*(g.MaxLat-g.MinLat)/float64(g.H)
+	lon = g.MinLon + (float64(x)+0.5)*(g.MaxLon-g.MinLon)/float64(g.W)
+	return lat, lon
+}
+
+type IDWOptions struct {
+	Power     float64 // distance exponent, 2 if 0
+	Radius    float64 // km, points further away are ignored, 0 for no limit
+	MaxPoints int     // nearest points used per cell, 12 if 0
+}
+
+// fill g by inverse distance weighting of points, cells with no point in
+// range stay NaN
+func (g *Grid) IDW(points []GridPoint, opts IDWOptions) {
+	if opts.Power == 0 {
+		opts.Power = 2
+	}
+	if opts.MaxPoints == 0 {
+		opts.MaxPoints = 12
+	}
+	lats, lons := make([]float64, len(points)), make([]float64, len(points))
+	for i, p := range points {
+		lats[i], lons[i] = p.Latitude, p.Longitude
+	}
+	tree := newKdTree(lats, lons)
+
+	for y := range g.H {
+		for x := range g.W {
+			lat, lon := g.Cell(x, y)
+			sum, wsum := 0.0, 0.0
+			for _, nb := range tree.nearest(unitVector(lat, lon), opts.MaxPoints) {
+				km := chordKm(nb.d2)
+				if opts.Radius > 0 && km > opts.Radius {
+					break
+				}
+				if km < 1e-6 { // on a point
+					sum, wsum = points[nb.i].Value, 1
+					break
+				}
+				w := 1 / math.Pow(km, opts.Power)
+				sum += w * points[nb.i].Value
+				wsum += w
+			}
+			if wsum > 0 {
+				g.Values[y*g.W+x] = sum / wsum
+			}
+		}
+	}
+}
+
+// min and max of the cells with data
+func (g *Grid) Range() (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range g.Values {
+		if !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	return lo, hi
+}
+
+// blue - white - red ramp of t in 0..1
+func heatColor(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	if t < 0.5 {
+		k := uint8(255 * t * 2)
+		return color.RGBA{k, k, 255, 255}
+	}
+	k := uint8(255 * (1 - t) * 2)
+	return color.RGBA{255, k, k, 255}
+}
+
+// png with values mapped lo..hi on a blue-white-red ramp, no data is transparent.
+// lo == hi uses the grid range, a constant grid is all white
+func (g *Grid) WritePng(filename string, lo, hi float64) error {
+	if lo == hi {
+		lo, hi = g.Range()
+	}
+	img := image.NewRGBA(image.Rect(0, 0, g.W, g.H))
+	for i, v := range g.Values {
+		if !math.IsNaN(v) {
+			t := 0.5
+			if hi > lo {
+				t = (v - lo) / (hi - lo)
+			}
+			img.SetRGBA(i%g.W, i/g.W, heatColor(t))
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		return fmt.Errorf("failed to encode image to PNG: %w", err)
+	}
+	return nil
+}
+
+const noData = -9999.0
+
+// esri float grid: little endian float32 rows from the north in name.flt
+// and the georeference in name.hdr. name is given without extension
+func (g *Grid) WriteRaw(name string) error {
+	name = strings.TrimSuffix(name, ".flt")
+	flt, err := os.Create(name + ".flt")
+	if err != nil {
+		return fmt.Errorf("failed to create file %s.flt: %w", name, err)
+	}
+	defer flt.Close()
+
+	w := bufio.NewWriter(flt)
+	for _, v := range g.Values {
+		if math.IsNaN(v) {
+			v = noData
+		}
+		if err := binary.Write(w, binary.LittleEndian, float32(v)); err != nil {
+			return fmt.Errorf("failed to write %s.flt: %w", name, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write %s.flt: %w", name, err)
+	}
+
+	// cellsize for square cells, xdim and ydim otherwise
+	xdim, ydim := (g.MaxLon-g.MinLon)/float64(g.W), (g.MaxLat-g.MinLat)/float64(g.H)
+	cells := fmt.Sprintf("cellsize %g\n", xdim)
+	if math.Abs(xdim-ydim) > 1e-9*xdim {
+		cells = fmt.Sprintf("xdim %g\nydim %g\n", xdim, ydim)
+	}
+	hdr := fmt.Sprintf("ncols %d\nnrows %d\nxllcorner %g\nyllcorner %g\n%snodata_value %g\nbyteorder LSBFIRST\n",
+		g.W, g.H, g.MinLon, g.MinLat, cells, noData)
+	if err := os.WriteFile(name+".hdr", []byte(hdr), 0644); err != nil {
+		return fmt.Errorf("failed to write %s.hdr: %w", name, err)
+	}
+	return nil
+}
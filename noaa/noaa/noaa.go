@@ -3,18 +3,21 @@
 package noaa
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
-	"log"
-	"math"
+	"io/fs"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"unsafe"
 )
 
-const NOAA_DATA_PATH = "/media/asd/data/code/noaadata/"
+const NOAA_DATA_PATH = "/media/asd/data/code/noaadata/" // default data dir, overridden by $NOAA_DATA_PATH
 const DailyTarBall = "ghcnd_all.tar.gz"
 const AuxFilePrefix = "ghcnd-"
 
@@ -43,10 +46,41 @@ type NOAA_DB struct {
 	Countries map[string]string
 	States    map[string]string
 	Elements  map[string]string
+	Registry  Registry // scale & unit of Elements
 	Stations  map[string]Station
-	Inventory map[string]string
+	Inventory map[string][]InventoryItem // by station id, nil without an inventory file
+
+	fsys     fs.FS  // data dir
+	tarball  string // daily tarball in fsys
+	dailyDir string // dir of station files in fsys instead of the tarball
+
+	spatialOnce sync.Once
+	spatial     *SpatialIndex
 }
 
+// data location
+type Config struct {
+	Path     string // data dir, DataPath() if empty
+	FS       fs.FS  // read from FS instead of Path, e.g. a testdata dir
+	Tarball  string // daily tarball name, DailyTarBall if empty
+	DailyDir string // pre-extracted .dly or by_station .csv.gz files dir, instead of the tarball
+}
+
+// malformed line of a data file
+type ParseError struct {
+	File string
+	Line int // 1 based line, record for .dly files
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+var ErrShortLine = errors.New("line too short")
+
 type Station struct {
 	Id           string
 	Latitude     float64
@@ -59,6 +93,16 @@ type Station struct {
 	Wmo_id       string
 }
 
+// element years of a station
+type InventoryItem struct {
+	Id        string
+	Latitude  float64
+	Longitude float64
+	Element   string
+	FirstYear int
+	LastYear  int
+}
+
 type DailyRaw struct {
 	id      [11]byte
 	year    [4]byte
@@ -92,81 +136,292 @@ type Item struct {
 
 ////
 
-func OpenDB() *NOAA_DB {
-	return &NOAA_DB{
-		Countries: ReadAuxFile("countries"),
-		States:    ReadAuxFile("states"),
-		Elements:  ReadAuxFile("elements"),
-		Stations:  ReadStations(),
-		// Inventory: ReadAuxFile("inventory"), // not yet interested
+// $NOAA_DATA_PATH or NOAA_DATA_PATH
+func DataPath() string {
+	if path := os.Getenv("NOAA_DATA_PATH"); path != "" {
+		return path
 	}
+	return NOAA_DATA_PATH
+}
+
+// open the db in the path data dir
+func Open(path string) (*NOAA_DB, error) {
+	return Config{Path: path}.Open()
 }
 
-func ReadAuxFile(file string) map[string]string {
-	data, err := os.ReadFile(NOAA_DATA_PATH + AuxFilePrefix + file + ".txt")
+// open the db from fsys, e.g. os.DirFS or fstest.MapFS
+func OpenFS(fsys fs.FS) (*NOAA_DB, error) {
+	return Config{FS: fsys}.Open()
+}
 
-	if err != nil {
-		log.Fatalf("failed to read file: %v", err)
+// read the aux files
+func (c Config) Open() (*NOAA_DB, error) {
+	fsys := c.FS
+	if fsys == nil {
+		path := c.Path
+		if path == "" {
+			path = DataPath()
+		}
+		fsys = os.DirFS(path)
 	}
+	db := &NOAA_DB{fsys: fsys, tarball: c.Tarball, dailyDir: c.DailyDir}
+	if db.tarball == "" {
+		db.tarball = DailyTarBall
+	}
+
+	var err error
+	if db.Countries, err = ReadAuxFile(fsys, "countries"); err != nil {
+		return nil, err
+	}
+	if db.States, err = ReadAuxFile(fsys, "states"); err != nil {
+		return nil, err
+	}
+	if db.Elements, err = ReadAuxFile(fsys, "elements"); err != nil {
+		return nil, err
+	}
+	db.Registry = NewRegistry(db.Elements)
+	if db.Stations, err = ReadStations(fsys); err != nil {
+		return nil, err
+	}
+	if db.Inventory, err = ReadInventory(fsys); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return db, nil
+}
 
-	var prefix []PosLen
-	if _prefix, ok := prefixMap[file]; !ok {
-		log.Fatalf("file %s not found", file)
+// hash of the names, sizes and mod times of the aux and daily files, changes
+// when the dataset is updated. e.g. for http etags
+func (db *NOAA_DB) Version() (string, error) {
+	h := fnv.New64a()
+	add := statHasher(db.fsys, h)
+	if err := db.addAux(add); err != nil {
+		return "", err
+	}
+	if db.dailyDir == "" {
+		if err := add(db.tarball); err != nil {
+			return "", err
+		}
+	} else if err := fs.WalkDir(db.fsys, db.dailyDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return add(name)
+	}); err != nil {
+		return "", fmt.Errorf("failed to read daily dir %s: %w", db.dailyDir, err)
+	}
+	return strconv.FormatUint(h.Sum64(), 36), nil
+}
+
+// cheap hash that changes when Version does after an update: the aux files,
+// the tarball or the daily dir, which ApplyDiffs renames station files into,
+// and its applied log. a changed stamp calls for a new Version
+func (db *NOAA_DB) Stamp() (string, error) {
+	h := fnv.New64a()
+	add := statHasher(db.fsys, h)
+	if err := db.addAux(add); err != nil {
+		return "", err
+	}
+	if db.dailyDir == "" {
+		if err := add(db.tarball); err != nil {
+			return "", err
+		}
 	} else {
-		prefix = _prefix
+		if err := add(db.dailyDir); err != nil {
+			return "", err
+		}
+		if err := add(path.Join(db.dailyDir, AppliedFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 36), nil
+}
+
+// adds name, size and mod time of a file in fsys to h
+func statHasher(fsys fs.FS, h io.Writer) func(name string) error {
+	return func(name string) error {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		fmt.Fprintf(h, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	}
+}
+
+func (db *NOAA_DB) addAux(add func(name string) error) error {
+	for _, file := range AuxFiles {
+		if err := add(AuxFilePrefix + file + ".txt"); err != nil && !(file == "inventory" && errors.Is(err, fs.ErrNotExist)) {
+			return err
+		}
+	}
+	return nil
+}
+
+// field pl of line, clipped to the line length
+func field(line string, pl PosLen) string {
+	if pl.pos >= len(line) {
+		return ""
+	}
+	return line[pl.pos:min(pl.pos+pl.len, len(line))]
+}
+
+func readAux(fsys fs.FS, file string) (string, []string, error) {
+	name := AuxFilePrefix + file + ".txt"
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return name, nil, fmt.Errorf("failed to read aux file %s: %w", name, err)
+	}
+	return name, strings.Split(strings.TrimRight(string(data), "\r\n"), "\n"), nil
+}
+
+// code -> name map of an aux file: countries, states or elements
+func ReadAuxFile(fsys fs.FS, file string) (map[string]string, error) {
+	prefix, ok := prefixMap[file]
+	if !ok {
+		return nil, fmt.Errorf("unknown aux file %s", file)
+	}
+	name, lines, err := readAux(fsys, file)
+	if err != nil {
+		return nil, err
 	}
 
 	m := make(map[string]string)
-	for line := range strings.SplitSeq(string(data), "\n") {
-		if line != "" {
-			m[line[prefix[0].pos:prefix[0].len]] = strings.TrimSpace(line[prefix[1].pos:min(prefix[1].pos+prefix[1].len, len(line))])
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
 		}
+		if len(line) < prefix[0].pos+prefix[0].len {
+			return nil, &ParseError{File: name, Line: i + 1, Err: ErrShortLine}
+		}
+		m[field(line, prefix[0])] = strings.TrimSpace(field(line, prefix[1]))
 	}
-	return m
+	return m, nil
 }
 
-func ReadStations() map[string]Station {
+func ReadStations(fsys fs.FS) (map[string]Station, error) {
+	name, lines, err := readAux(fsys, "stations")
+	if err != nil {
+		return nil, err
+	}
+
 	m := make(map[string]Station)
-	data, err := os.ReadFile(NOAA_DATA_PATH + AuxFilePrefix + "stations" + ".txt")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < STATIONS_FS[3].pos+STATIONS_FS[3].len { // up to elevation
+			return nil, &ParseError{File: name, Line: i + 1, Err: ErrShortLine}
+		}
+
+		var coords [3]float64
+		for k := range coords {
+			f, err := strconv.ParseFloat(strings.TrimSpace(field(line, STATIONS_FS[k+1])), 64)
+			if err != nil {
+				return nil, &ParseError{File: name, Line: i + 1, Err: err}
+			}
+			coords[k] = f
+		}
+
+		id := field(line, STATIONS_FS[0])
+		m[id] = Station{
+			Id:           id,
+			Latitude:     coords[0],
+			Longitude:    coords[1],
+			Elevation:    coords[2],
+			State:        field(line, STATIONS_FS[4]),
+			Name:         field(line, STATIONS_FS[5]),
+			Gsn_flag:     field(line, STATIONS_FS[6]),
+			Hcn_crn_flag: field(line, STATIONS_FS[7]),
+			Wmo_id:       field(line, STATIONS_FS[8]),
+		}
+	}
+	return m, nil
+}
+
+func ReadInventory(fsys fs.FS) (map[string][]InventoryItem, error) {
+	name, lines, err := readAux(fsys, "inventory")
 	if err != nil {
-		log.Fatalf("failed to read file: %v", err)
+		return nil, err
 	}
 
-	toFloat64 := func(s string) float64 {
-		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
-		if err != nil {
-			log.Fatalf("failed to parse float: %v", err)
+	m := make(map[string][]InventoryItem)
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < INVENTORY_FS[5].pos+INVENTORY_FS[5].len {
+			return nil, &ParseError{File: name, Line: i + 1, Err: ErrShortLine}
+		}
+
+		var nums [4]float64 // lat, lon, first & last year
+		for k, pl := range []PosLen{INVENTORY_FS[1], INVENTORY_FS[2], INVENTORY_FS[4], INVENTORY_FS[5]} {
+			f, err := strconv.ParseFloat(strings.TrimSpace(field(line, pl)), 64)
+			if err != nil {
+				return nil, &ParseError{File: name, Line: i + 1, Err: err}
+			}
+			nums[k] = f
 		}
-		return f
+
+		id := field(line, INVENTORY_FS[0])
+		m[id] = append(m[id], InventoryItem{
+			Id:        id,
+			Latitude:  nums[0],
+			Longitude: nums[1],
+			Element:   field(line, INVENTORY_FS[3]),
+			FirstYear: int(nums[2]),
+			LastYear:  int(nums[3]),
+		})
 	}
+	return m, nil
+}
 
-	for line := range strings.SplitSeq(string(data), "\n") {
-		if line != "" {
-			m[line[STATIONS_FS[0].pos:STATIONS_FS[0].pos+STATIONS_FS[0].len]] = Station{
-				Id:           line[STATIONS_FS[0].pos : STATIONS_FS[0].pos+STATIONS_FS[0].len],
-				Latitude:     toFloat64(line[STATIONS_FS[1].pos : STATIONS_FS[1].pos+STATIONS_FS[1].len]),
-				Longitude:    toFloat64(line[STATIONS_FS[2].pos : STATIONS_FS[2].pos+STATIONS_FS[2].len]),
-				Elevation:    toFloat64(line[STATIONS_FS[3].pos : STATIONS_FS[3].pos+STATIONS_FS[3].len]),
-				State:        line[STATIONS_FS[4].pos : STATIONS_FS[4].pos+STATIONS_FS[4].len],
-				Name:         line[STATIONS_FS[5].pos : STATIONS_FS[5].pos+STATIONS_FS[5].len],
-				Gsn_flag:     line[STATIONS_FS[6].pos : STATIONS_FS[6].pos+STATIONS_FS[6].len],
-				Hcn_crn_flag: line[STATIONS_FS[7].pos : STATIONS_FS[7].pos+STATIONS_FS[7].len],
-				Wmo_id:       line[STATIONS_FS[8].pos : STATIONS_FS[8].pos+STATIONS_FS[8].len],
+// sorted ids of the inventory stations with element data overlapping
+// [fromYear, toYear], 0 for an open bound. country is an id prefix, "" for all
+func (db *NOAA_DB) InventoryStations(element, country string, fromYear, toYear int) []string {
+	var ids []string
+	for id, items := range db.Inventory {
+		if !strings.HasPrefix(id, country) {
+			continue
+		}
+		for _, item := range items {
+			if item.Element == element && (toYear == 0 || item.FirstYear <= toYear) && (fromYear == 0 || item.LastYear >= fromYear) {
+				ids = append(ids, id)
+				break
 			}
 		}
 	}
-	return m
+	slices.Sort(ids)
+	return ids
 }
 
 // daily obs
 
-func NewDailiesRaw(dataFile []byte) []DailyRaw {
+// fixed size .dly records, the last one may miss its line feed.
+// a malformed record returns a *ParseError with its line, File is left to the caller
+func NewDailiesRaw(dataFile []byte) ([]DailyRaw, error) {
 	var dailyRaw DailyRaw
 	var dailyRaws []DailyRaw
-	szDaily := unsafe.Sizeof(dailyRaw)
+	szDaily := int(unsafe.Sizeof(dailyRaw))
+
+	for i := 0; i < len(dataFile); i += szDaily {
+		line := i/szDaily + 1
+		dataRec := dataFile[i:min(i+szDaily, len(dataFile))]
+		if len(dataRec) == szDaily-1 { // no final lf
+			dataRec = append(dataRec[:len(dataRec):len(dataRec)], '\n')
+		}
+		if len(dataRec) < szDaily {
+			return nil, &ParseError{Line: line, Err: ErrShortLine}
+		}
+		if dataRec[szDaily-1] != '\n' {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("record not %d bytes long", szDaily)}
+		}
+		if _, err := strconv.Atoi(string(dataRec[11:17])); err != nil {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("bad year/month: %w", err)}
+		}
 
-	for i := 0; i < len(dataFile); i += int(szDaily) {
-		dataRec := dataFile[i : i+int(szDaily)]
 		copy(dailyRaw.id[:], dataRec[:11])
 		copy(dailyRaw.year[:], dataRec[11:15])
 		copy(dailyRaw.month[:], dataRec[15:17])
@@ -177,10 +432,10 @@ func NewDailiesRaw(dataFile []byte) []DailyRaw {
 			dailyRaw.items[j].qflag = dataRec[27+j*8]
 			dailyRaw.items[j].sflag = dataRec[28+j*8]
 		}
-		dailyRaw.lf = dataRec[156]
+		dailyRaw.lf = dataRec[szDaily-1]
 		dailyRaws = append(dailyRaws, dailyRaw)
 	}
-	return dailyRaws
+	return dailyRaws, nil
 }
 
 func NewDaily(dailyRaw DailyRaw) Daily {
@@ -220,6 +475,8 @@ func (dr *DailyRaw) Month() int {
 func (dr *DailyRaw) Element() string {
 	return string(dr.element[:])
 }
+
+// raw value of day d, 0 for a missing value. see Day & Valid
 func (dr *DailyRaw) Value(d int) int {
 	value, _ := strconv.Atoi(strings.TrimSpace(string(dr.items[d].value[:])))
 	if value == -9999 && dr.Qflag(d) == ' ' {
@@ -228,17 +485,12 @@ func (dr *DailyRaw) Value(d int) int {
 	return value
 }
 
+// average of the valid days passing all quality checks, 0 without valid days
 func (dr *DailyRaw) Avg() float64 {
-	sum := 0.0
-	count := 0
-	for d := 0; d < 31; d++ {
-		sum += float64(dr.Value(d))
-		count++
-	}
-	if count == 0 {
-		return 0
+	if s := dr.Stats(AllQflags); s.Count > 0 {
+		return s.Avg()
 	}
-	return sum / float64(count)
+	return 0
 }
 func MaxInt(a, b int) int {
 	if a > b {
@@ -246,43 +498,19 @@ func MaxInt(a, b int) int {
 	}
 	return b
 }
+
+// min of the valid days passing all quality checks, 0 without valid days
 func (dr *DailyRaw) Min() int {
-	min := math.MaxInt
-	for d := 0; d < 31; d++ {
-		if dr.Value(d) < min {
-			min = dr.Value(d)
-		}
-	}
-	return min
+	return dr.Stats(AllQflags).Min
 }
+
+// max of the valid days passing all quality checks, 0 without valid days
 func (dr *DailyRaw) Max() int {
-	max := math.MinInt
-	for d := 0; d < 31; d++ {
-		if dr.Value(d) > max {
-			max = dr.Value(d)
-		}
-	}
-	return max
+	return dr.Stats(AllQflags).Max
 }
 func (dr *DailyRaw) MinMaxAvg() (int, int, float64) {
-	min := math.MaxInt
-	max := math.MinInt
-	sum := 0.0
-	count := 0
-	for d := 0; d < 31; d++ {
-		if dr.Value(d) < min {
-			min = dr.Value(d)
-		}
-		if dr.Value(d) > max {
-			max = dr.Value(d)
-		}
-		sum += float64(dr.Value(d))
-		count++
-	}
-	if count == 0 {
-		return 0, 0, 0
-	}
-	return min, max, sum / float64(count)
+	s := dr.Stats(AllQflags)
+	return s.Min, s.Max, dr.Avg()
 }
 
 func (dr *DailyRaw) Mflag(d int) byte {
@@ -294,78 +522,3 @@ func (dr *DailyRaw) Qflag(d int) byte {
 func (dr *DailyRaw) Sflag(d int) byte {
 	return dr.items[d].sflag
 }
-
-// /
-type DailyTraverse struct {
-	Filter       func(DailyRaw, Station) bool
-	Progress     func(int, *DailyTraverse, *DailyRaw)
-	FoundFunc    func(DailyRaw, unsafe.Pointer)
-	ProgressStop int
-	MaxFound     int
-	CountFound   int
-	MaxTraverse  int
-	DataPtr      unsafe.Pointer
-}
-
-func TraverseDaily(db *NOAA_DB, dt DailyTraverse) {
-	filePath := NOAA_DATA_PATH + DailyTarBall
-
-	// Open the gzipped file
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Fatalf("failed to open file: %v", err)
-	}
-	defer file.Close()
-
-	// Create a new gzip reader
-	gzr, err := gzip.NewReader(file)
-	if err != nil {
-		log.Fatalf("failed to create gzip reader: %v", err)
-	}
-	defer gzr.Close()
-
-	// Create a new tar reader on top of the gzip reader
-	tr := tar.NewReader(gzr)
-	totFiles := 0
-
-	// Iterate through the files in the archive
-	for nfiles := 0; ; nfiles++ {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break // End of archive
-		}
-		if err != nil {
-			log.Fatalf("failed to read next tar entry: %v", err)
-		}
-		if header.Size == 0 || !strings.Contains(header.Name, ".dly") { // skip empty files and non-daily files
-			continue
-		}
-		if (dt.MaxTraverse > 0 && nfiles >= dt.MaxTraverse) || // by nfiles or #found
-			(dt.MaxFound > 0 && dt.CountFound >= dt.MaxFound) {
-			break
-		}
-
-		data, err := io.ReadAll(tr) // read file
-		if err != nil {
-			log.Fatalf("failed to read file content: %v", err)
-		}
-
-		dailysRaw := NewDailiesRaw(data) // create daily array
-
-		station := db.Stations[string(dailysRaw[0].id[:])] // get station
-
-		if dt.ProgressStop > 0 && dt.Progress != nil && nfiles%dt.ProgressStop == 0 { // progress
-			dt.Progress(nfiles, &dt, &dailysRaw[0])
-		}
-
-		for _, drec := range dailysRaw { // traverse files = daily array
-			if dt.Filter != nil && dt.Filter(drec, station) {
-				if dt.FoundFunc != nil {
-					dt.FoundFunc(drec, dt.DataPtr)
-				}
-				dt.CountFound++
-			}
-		}
-		totFiles++
-	}
-}
@@ -0,0 +1,407 @@
+// parallel daily decoding: inflate -> entry split -> parse/filter workers -> merge
+// from the tarball, a dir of .dly files or a dir of per station .csv.gz files
+
+package noaa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DefaultMemoryBudget = 256 << 20 // station file bytes in flight
+
+// raw station file read by the source
+type stationFile struct {
+	seq  int
+	name string
+	data []byte
+}
+
+// parsed and filtered station file
+type stationRecords struct {
+	seq     int
+	size    int // raw bytes to release from the budget
+	records []DailyRaw
+	station Station
+	year    int // first year of the file
+	err     error
+}
+
+// bytes in flight between the source and the merge
+type budget struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	free   int64
+	max    int64
+	closed bool
+}
+
+func newBudget(max int64) *budget {
+	b := &budget{free: max, max: max}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// wait for n bytes, a file larger than the budget takes it all.
+// false when the budget was closed
+func (b *budget) acquire(n int64) bool {
+	n = min(n, b.max)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.free < n && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return false
+	}
+	b.free -= n
+	return true
+}
+
+func (b *budget) release(n int64) {
+	n = min(n, b.max)
+	b.mu.Lock()
+	b.free += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *budget) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// send station files of the configured source to out
+func (db *NOAA_DB) readStationFiles(ctx context.Context, opts TraverseOptions, bud *budget, out chan<- stationFile) error {
+	seq := 0
+	maxFiles := opts.MaxFiles
+	emit := func(name string, size int64, r io.Reader) error {
+		if !bud.acquire(size) {
+			return ctx.Err()
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			bud.release(size)
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		select {
+		case out <- stationFile{seq: seq, name: name, data: data}:
+			seq++
+			return nil
+		case <-ctx.Done():
+			bud.release(size)
+			return ctx.Err()
+		}
+	}
+	done := func() bool { return maxFiles > 0 && seq >= maxFiles }
+
+	if db.dailyDir != "" {
+		return fs.WalkDir(db.fsys, db.dailyDir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return fmt.Errorf("failed to read daily dir %s: %w", db.dailyDir, err)
+			}
+			if d.IsDir() || !isStationFile(name) || (opts.Stations != nil && !opts.Stations(stationFileId(name))) {
+				return nil
+			}
+			if done() {
+				return fs.SkipAll
+			}
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", name, err)
+			}
+			file, err := db.fsys.Open(name)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", name, err)
+			}
+			defer file.Close()
+			return emit(name, info.Size(), file)
+		})
+	}
+
+	// Open the gzipped file
+	file, err := db.fsys.Open(db.tarball)
+	if err != nil {
+		return fmt.Errorf("failed to open daily tarball: %w", err)
+	}
+	defer file.Close()
+
+	// Create a new gzip reader
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader for %s: %w", db.tarball, err)
+	}
+	defer gzr.Close()
+
+	// inflate in its own goroutine, the tar entries are split from the pipe
+	pr, pw := io.Pipe()
+	inflated := make(chan struct{})
+	defer func() {
+		// stop the inflater on early return, wait for it before gzr and file close
+		pr.CloseWithError(context.Canceled)
+		<-inflated
+	}()
+	go func() {
+		defer close(inflated)
+		_, err := io.Copy(pw, gzr)
+		pw.CloseWithError(err)
+	}()
+
+	tr := tar.NewReader(pr)
+	for !done() {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil // End of archive
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read next tar entry in %s: %w", db.tarball, err)
+		}
+		if header.Size == 0 || !isStationFile(header.Name) { // skip empty files and non-daily files
+			continue
+		}
+		if opts.Stations != nil && !opts.Stations(stationFileId(header.Name)) {
+			continue
+		}
+		if err := emit(header.Name, header.Size, tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isStationFile(name string) bool {
+	return strings.HasSuffix(name, ".dly") || strings.HasSuffix(name, ".csv.gz")
+}
+
+// station id of a station file name
+func stationFileId(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(strings.TrimSuffix(base, ".dly"), ".csv.gz")
+}
+
+// parse a .dly or .csv.gz station file, a *ParseError gets the file name
+func parseStationFile(name string, data []byte) ([]DailyRaw, error) {
+	var records []DailyRaw
+	var err error
+	if strings.HasSuffix(name, ".csv.gz") {
+		records, err = NewDailiesCsvGz(data)
+	} else {
+		records, err = NewDailiesRaw(data)
+	}
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		perr.File = name
+	}
+	return records, err
+}
+
+// parse and filter station files on opts.Workers goroutines, then yield their
+// records in file order when opts.Ordered, as they are ready otherwise
+func (db *NOAA_DB) traversePipeline(ctx context.Context, opts TraverseOptions, yield func(DailyRaw, Station) bool) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	memory := opts.MemoryBudget
+	if memory <= 0 {
+		memory = DefaultMemoryBudget
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bud := newBudget(memory)
+	context.AfterFunc(ctx, bud.close)
+
+	files := make(chan stationFile, workers)
+	results := make(chan stationRecords, workers)
+
+	var readErr error
+	go func() {
+		defer close(files)
+		readErr = db.readStationFiles(ctx, opts, bud, files)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				res := stationRecords{seq: f.seq, size: len(f.data)}
+				records, err := parseStationFile(f.name, f.data)
+				if res.err = err; err == nil && len(records) > 0 {
+					res.station = db.Stations[records[0].Id()] // get station
+					res.year = records[0].Year()
+					for _, drec := range records {
+						if opts.Filter == nil || opts.Filter(drec, res.station) {
+							res.records = append(res.records, drec)
+						}
+					}
+				}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					bud.release(int64(res.size))
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	progress := Progress{}
+	pending := map[int]stationRecords{} // out of order results when ordered
+	next := 0
+	var err error
+
+	// yield the records of one file, false to stop
+	consume := func(res stationRecords) bool {
+		defer bud.release(int64(res.size))
+		if res.err != nil {
+			err = res.err
+			return false
+		}
+		if opts.ProgressEvery > 0 && opts.Progress != nil && progress.Files%opts.ProgressEvery == 0 {
+			progress.Id, progress.Year = res.station.Id, res.year
+			opts.Progress(progress)
+		}
+		progress.Files++
+
+		for _, drec := range res.records {
+			progress.Found++
+			if !yield(drec, res.station) || (opts.MaxFound > 0 && progress.Found >= opts.MaxFound) {
+				return false
+			}
+		}
+		return true
+	}
+
+	stopped := false
+	for res := range results {
+		if stopped {
+			bud.release(int64(res.size)) // drain
+			continue
+		}
+		if !opts.Ordered {
+			stopped = !consume(res)
+		} else {
+			pending[res.seq] = res
+			for r, ok := pending[next]; ok && !stopped; r, ok = pending[next] {
+				delete(pending, next)
+				next++
+				stopped = !consume(r)
+			}
+		}
+		if stopped {
+			cancel()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	if stopped { // by yield or limits, not an error
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return ctx.Err()
+}
+
+// per station csv of the by_station distribution:
+// ID,YYYYMMDD,ELEMENT,VALUE,MFLAG,QFLAG,SFLAG,OBSTIME
+// grouped into monthly records sorted by year, month and element
+func NewDailiesCsvGz(data []byte) ([]DailyRaw, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzr.Close()
+	return NewDailiesCsv(gzr)
+}
+
+func NewDailiesCsv(r io.Reader) ([]DailyRaw, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	index := map[string]int{} // year month element -> record
+	var records []DailyRaw
+	for line := 1; ; line++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ParseError{Line: line, Err: err}
+		}
+		if len(fields) < 7 || len(fields[0]) != 11 || len(fields[1]) != 8 || len(fields[2]) != 4 {
+			return nil, &ParseError{Line: line, Err: ErrShortLine}
+		}
+		day, err := strconv.Atoi(fields[1][6:])
+		if err != nil || day < 1 || day > 31 {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("bad date %s", fields[1])}
+		}
+		value, err := strconv.Atoi(fields[3])
+		if err != nil || value < -9999 || value > 99999 {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("bad value %s", fields[3])}
+		}
+
+		key := fields[1][:6] + fields[2]
+		i, ok := index[key]
+		if !ok {
+			i = len(records)
+			index[key] = i
+			records = append(records, newDailyRaw(fields[0], fields[1][:4], fields[1][4:6], fields[2]))
+		}
+		records[i].setItem(day-1, value, flag(fields[4]), flag(fields[5]), flag(fields[6]))
+	}
+
+	key := func(dr DailyRaw) string { return string(dr.year[:]) + string(dr.month[:]) + string(dr.element[:]) }
+	slices.SortStableFunc(records, func(a, b DailyRaw) int { return strings.Compare(key(a), key(b)) })
+	return records, nil
+}
+
+func flag(s string) byte {
+	if s == "" {
+		return ' '
+	}
+	return s[0]
+}
+
+// record with all days missing
+func newDailyRaw(id, year, month, element string) DailyRaw {
+	var dr DailyRaw
+	copy(dr.id[:], id)
+	copy(dr.year[:], year)
+	copy(dr.month[:], month)
+	copy(dr.element[:], element)
+	for d := range dr.items {
+		dr.setItem(d, -9999, ' ', ' ', ' ')
+	}
+	dr.lf = '\n'
+	return dr
+}
+
+func (dr *DailyRaw) setItem(d, value int, mflag, qflag, sflag byte) {
+	copy(dr.items[d].value[:], fmt.Sprintf("%5d", value))
+	dr.items[d].mflag, dr.items[d].qflag, dr.items[d].sflag = mflag, qflag, sflag
+}
@@ -0,0 +1,307 @@
+// spatial station queries: k-d tree on unit sphere vectors for radius and
+// nearest-n, bounding box, country and state filters
+
+package noaa
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+const EarthRadius = 6371.0 // km
+
+// great circle distance in km
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dlat, dlon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// unit vector of lat, lon so chord distance orders like great circle distance
+func unitVector(lat, lon float64) [3]float64 {
+	rad := math.Pi / 180
+	return [3]float64{math.Cos(lat*rad) * math.Cos(lon*rad), math.Cos(lat*rad) * math.Sin(lon*rad), math.Sin(lat * rad)}
+}
+
+// squared chord of a great circle distance in km
+func chord2(km float64) float64 {
+	c := 2 * math.Sin(math.Min(km/EarthRadius, math.Pi)/2)
+	return c * c
+}
+
+func dist2(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// implicit k-d tree: each idx range has its node at the middle
+type kdTree struct {
+	pts [][3]float64
+	idx []int
+}
+
+func newKdTree(lats, lons []float64) *kdTree {
+	t := &kdTree{pts: make([][3]float64, len(lats)), idx: make([]int, len(lats))}
+	for i := range lats {
+		t.pts[i] = unitVector(lats[i], lons[i])
+		t.idx[i] = i
+	}
+	t.build(t.idx, 0)
+	return t
+}
+
+func (t *kdTree) build(idx []int, depth int) {
+	if len(idx) < 2 {
+		return
+	}
+	axis := depth % 3
+	slices.SortFunc(idx, func(a, b int) int { return cmp.Compare(t.pts[a][axis], t.pts[b][axis]) })
+	mid := len(idx) / 2
+	t.build(idx[:mid], depth+1)
+	t.build(idx[mid+1:], depth+1)
+}
+
+// points within squared chord r2 of q
+func (t *kdTree) within(q [3]float64, r2 float64, lo, hi, depth int, found func(i int, d2 float64)) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	i := t.idx[mid]
+	if d2 := dist2(t.pts[i], q); d2 <= r2 {
+		found(i, d2)
+	}
+	diff := q[depth%3] - t.pts[i][depth%3]
+	near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+	if diff > 0 {
+		near, far = far, near
+	}
+	t.within(q, r2, near[0], near[1], depth+1, found)
+	if diff*diff <= r2 {
+		t.within(q, r2, far[0], far[1], depth+1, found)
+	}
+}
+
+type neighbor struct {
+	i  int
+	d2 float64
+}
+
+// n nearest points of q sorted by distance
+func (t *kdTree) nearest(q [3]float64, n int) []neighbor {
+	best := make([]neighbor, 0, n+1)
+	var visit func(lo, hi, depth int)
+	visit = func(lo, hi, depth int) {
+		if lo >= hi {
+			return
+		}
+		mid := (lo + hi) / 2
+		i := t.idx[mid]
+		d2 := dist2(t.pts[i], q)
+		if len(best) < n || d2 < best[len(best)-1].d2 {
+			at, _ := slices.BinarySearchFunc(best, d2, func(nb neighbor, d float64) int {
+				if nb.d2 <= d {
+					return -1
+				}
+				return 1
+			})
+			best = slices.Insert(best, at, neighbor{i, d2})
+			if len(best) > n {
+				best = best[:n]
+			}
+		}
+		diff := q[depth%3] - t.pts[i][depth%3]
+		near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+		if diff > 0 {
+			near, far = far, near
+		}
+		visit(near[0], near[1], depth+1)
+		if len(best) < n || diff*diff < best[len(best)-1].d2 {
+			visit(far[0], far[1], depth+1)
+		}
+	}
+	if n > 0 {
+		visit(0, len(t.idx), 0)
+	}
+	return best
+}
+
+// chord back to km
+func chordKm(d2 float64) float64 {
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(d2)/2))
+}
+
+type StationDistance struct {
+	Station
+	Distance float64 // km
+}
+
+// stations by position, safe for concurrent queries
+type SpatialIndex struct {
+	stations []Station
+	tree     *kdTree
+}
+
+func NewSpatialIndex(stations map[string]Station) *SpatialIndex {
+	si := &SpatialIndex{}
+	for _, id := range slices.Sorted(maps.Keys(stations)) {
+		si.stations = append(si.stations, stations[id])
+	}
+	lats, lons := make([]float64, len(si.stations)), make([]float64, len(si.stations))
+	for i, s := range si.stations {
+		lats[i], lons[i] = s.Latitude, s.Longitude
+	}
+	si.tree = newKdTree(lats, lons)
+	return si
+}
+
+// stations within km of lat, lon sorted by distance
+func (si *SpatialIndex) Within(lat, lon, km float64) []StationDistance {
+	var found []StationDistance
+	si.tree.within(unitVector(lat, lon), chord2(km), 0, len(si.tree.idx), 0, func(i int, d2 float64) {
+		found = append(found, StationDistance{si.stations[i], chordKm(d2)})
+	})
+	slices.SortFunc(found, func(a, b StationDistance) int { return cmp.Compare(a.Distance, b.Distance) })
+	return found
+}
+
+// n nearest stations of lat, lon
+func (si *SpatialIndex) Nearest(lat, lon float64, n int) []StationDistance {
+	var found []StationDistance
+	for _, nb := range si.tree.nearest(unitVector(lat, lon), n) {
+		found = append(found, StationDistance{si.stations[nb.i], chordKm(nb.d2)})
+	}
+	return found
+}
+
+// stations in the box, minLon > maxLon crosses the antimeridian
+func (si *SpatialIndex) BBox(minLat, minLon, maxLat, maxLon float64) []Station {
+	var found []Station
+	for _, s := range si.stations {
+		inLon := s.Longitude >= minLon && s.Longitude <= maxLon
+		if minLon > maxLon {
+			inLon = s.Longitude >= minLon || s.Longitude <= maxLon
+		}
+		if inLon && s.Latitude >= minLat && s.Latitude <= maxLat {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// spatial index of db.Stations, built on first use
+func (db *NOAA_DB) Spatial() *SpatialIndex {
+	db.spatialOnce.Do(func() { db.spatial = NewSpatialIndex(db.Stations) })
+	return db.spatial
+}
+
+// country code of a code or a case insensitive name in Countries
+func (db *NOAA_DB) CountryCode(country string) (string, bool) {
+	return lookupCode(db.Countries, country)
+}
+
+// state code of a code or a case insensitive name in States
+func (db *NOAA_DB) StateCode(state string) (string, bool) {
+	return lookupCode(db.States, state)
+}
+
+func lookupCode(codes map[string]string, s string) (string, bool) {
+	if _, ok := codes[strings.ToUpper(s)]; ok {
+		return strings.ToUpper(s), true
+	}
+	for code, name := range codes {
+		if strings.EqualFold(name, s) {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// stations of a country and state, codes or names, "" for any. sorted by id
+func (db *NOAA_DB) StationsIn(country, state string) ([]Station, bool) {
+	var ok bool
+	if country != "" {
+		if country, ok = db.CountryCode(country); !ok {
+			return nil, false
+		}
+	}
+	if state != "" {
+		if state, ok = db.StateCode(state); !ok {
+			return nil, false
+		}
+	}
+	var found []Station
+	for _, id := range slices.Sorted(maps.Keys(db.Stations)) {
+		s := db.Stations[id]
+		if strings.HasPrefix(id, country) && (state == "" || strings.TrimSpace(s.State) == state) {
+			found = append(found, s)
+		}
+	}
+	return found, true
+}
+
+// station selection by country, state, ids and position, zero fields don't restrict
+type StationQuery struct {
+	Country  string   // code or name
+	State    string   // code or name
+	Ids      []string // station ids
+	Near     bool     // select around Lat, Lon
+	Lat, Lon float64
+	Radius   float64 // km of a Near query
+	Nearest  int     // the n nearest instead of a radius
+}
+
+// stations of q sorted by id, or by distance for a Near query
+func (db *NOAA_DB) SelectStations(q StationQuery) ([]StationDistance, error) {
+	country, state := "", ""
+	var ok bool
+	if q.Country != "" {
+		if country, ok = db.CountryCode(q.Country); !ok {
+			return nil, fmt.Errorf("unknown country %q", q.Country)
+		}
+	}
+	if q.State != "" {
+		if state, ok = db.StateCode(q.State); !ok {
+			return nil, fmt.Errorf("unknown state %q", q.State)
+		}
+	}
+
+	var candidates []StationDistance
+	switch {
+	case q.Near:
+		if q.Lat < -90 || q.Lat > 90 || q.Lon < -180 || q.Lon > 180 {
+			return nil, fmt.Errorf("invalid position %g,%g", q.Lat, q.Lon)
+		}
+		if q.Nearest > 0 {
+			candidates = db.Spatial().Nearest(q.Lat, q.Lon, q.Nearest)
+		} else {
+			candidates = db.Spatial().Within(q.Lat, q.Lon, q.Radius)
+		}
+	case q.Ids != nil:
+		for _, id := range slices.Sorted(slices.Values(q.Ids)) {
+			s, ok := db.Stations[id]
+			if !ok {
+				return nil, fmt.Errorf("unknown station %q", id)
+			}
+			candidates = append(candidates, StationDistance{Station: s})
+		}
+	default:
+		for _, s := range db.Spatial().stations { // sorted by id
+			candidates = append(candidates, StationDistance{Station: s})
+		}
+	}
+
+	var selected []StationDistance
+	for _, s := range candidates {
+		if strings.HasPrefix(s.Id, country) && (state == "" || strings.TrimSpace(s.State) == state) &&
+			(!q.Near || q.Ids == nil || slices.Contains(q.Ids, s.Id)) {
+			selected = append(selected, s)
+		}
+	}
+	return selected, nil
+}
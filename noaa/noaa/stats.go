@@ -0,0 +1,98 @@
+// missing value and quality flag aware daily statistics
+
+package noaa
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// qflags of values failing a quality check, see the ghcnd readme
+const AllQflags = "DGIKLMNORSTWXZ"
+
+// aggregate of the valid days of one or more monthly records
+type Stat struct {
+	Min, Max int
+	Sum      float64
+	Count    int // valid days
+	Days     int // calendar days covered
+}
+
+func (s Stat) Avg() float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// valid days / calendar days
+func (s Stat) Coverage() float64 {
+	if s.Days == 0 {
+		return 0
+	}
+	return float64(s.Count) / float64(s.Days)
+}
+
+func (s Stat) Merge(o Stat) Stat {
+	if o.Count > 0 {
+		if s.Count == 0 || o.Min < s.Min {
+			s.Min = o.Min
+		}
+		if s.Count == 0 || o.Max > s.Max {
+			s.Max = o.Max
+		}
+	}
+	s.Sum += o.Sum
+	s.Count += o.Count
+	s.Days += o.Days
+	return s
+}
+
+func (s Stat) Add(v int) Stat {
+	return s.Merge(Stat{Min: v, Max: v, Sum: float64(v), Count: 1})
+}
+
+// days in month of year
+func DaysIn(year, month int) int {
+	return time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func (dr *DailyRaw) Days() int {
+	return DaysIn(dr.Year(), dr.Month())
+}
+
+// value of day d (0 based), false when missing or past the month end
+func (dr *DailyRaw) Day(d int) (int, bool) {
+	if d < 0 || d >= dr.Days() {
+		return 0, false
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(dr.items[d].value[:])))
+	if err != nil || value == missing {
+		return 0, false
+	}
+	return value, true
+}
+
+// value of day d, false when missing or its qflag is in reject
+func (dr *DailyRaw) Valid(d int, reject string) (int, bool) {
+	value, ok := dr.Day(d)
+	if !ok || (dr.Qflag(d) != ' ' && strings.IndexByte(reject, dr.Qflag(d)) >= 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+// stats of the valid days, reject lists the qflags to skip: AllQflags, "" for none
+func (dr *DailyRaw) Stats(reject string) Stat {
+	s := Stat{}
+	days := dr.Days()
+	for d := range days {
+		if value, ok := dr.Valid(d, reject); ok {
+			s = s.Add(value)
+		}
+	}
+	s.Days = days
+	return s
+}
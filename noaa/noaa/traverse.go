@@ -0,0 +1,58 @@
+// daily tarball traversal: iterator and generic accumulator
+
+package noaa
+
+import (
+	"context"
+	"iter"
+)
+
+type TraverseOptions struct {
+	Filter        func(DailyRaw, Station) bool // nil accepts all records
+	Stations      func(id string) bool         // skips station files before parsing, nil reads all
+	Progress      func(Progress)
+	ProgressEvery int // files between Progress calls, 0 for none
+	MaxFiles      int // stop after # station files, 0 for all
+	MaxFound      int // stop after # accepted records, 0 for all
+
+	Workers      int   // parse/filter goroutines, 0 for all cores
+	Ordered      bool  // yield in station file order, otherwise as parsed
+	MemoryBudget int64 // max station file bytes in flight, 0 for DefaultMemoryBudget
+}
+
+// traversal state passed to TraverseOptions.Progress
+type Progress struct {
+	Files int    // station files read
+	Found int    // records accepted
+	Id    string // current station
+	Year  int    // first year of the current station
+}
+
+// read the daily files calling yield on filtered records until yield returns false,
+// the options limits are reached or ctx is cancelled
+func (db *NOAA_DB) traverse(ctx context.Context, opts TraverseOptions, yield func(DailyRaw, Station) bool) error {
+	return db.traversePipeline(ctx, opts, yield)
+}
+
+// iterator over the filtered records and their stations, the returned func
+// reports the error that stopped the iteration if any
+//
+//	seq, errf := db.Dailies(ctx, opts)
+//	for dr, station := range seq { ... }
+//	if err := errf(); err != nil { ... }
+func (db *NOAA_DB) Dailies(ctx context.Context, opts TraverseOptions) (iter.Seq2[DailyRaw, Station], func() error) {
+	var err error
+	seq := func(yield func(DailyRaw, Station) bool) {
+		err = db.traverse(ctx, opts, yield)
+	}
+	return seq, func() error { return err }
+}
+
+// fold the filtered records into acc
+func Traverse[T any](ctx context.Context, db *NOAA_DB, opts TraverseOptions, acc T, fn func(T, DailyRaw, Station) T) (T, error) {
+	err := db.traverse(ctx, opts, func(dr DailyRaw, station Station) bool {
+		acc = fn(acc, dr, station)
+		return true
+	})
+	return acc, err
+}
@@ -0,0 +1,418 @@
+// incremental updates of a .dly station dir from the superghcnd diff tarballs,
+// superghcnd_diff_YYYYMMDD_to_YYYYMMDD.tar.gz with insert.csv, update.csv and
+// delete.csv of ID,YYYYMMDD,ELEMENT,VALUE,M,Q,S,OBSTIME rows.
+//
+// applied diffs are logged to <dir>/superghcnd.applied as "<to date> <file>"
+// lines, the last one being the date the dir is current to. each change sets
+// a day's value or clears it, so reapplying an interrupted diff is harmless
+
+package noaa
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const AppliedFile = "superghcnd.applied"
+
+var ErrDiffGap = errors.New("diff does not start at the last applied date")
+
+var diffName = regexp.MustCompile(`superghcnd_diff_(\d{8})_to_(\d{8})\.tar\.gz$`)
+
+type ChangeKind int
+
+const (
+	Insert ChangeKind = iota
+	Update
+	Delete
+)
+
+var changeFiles = map[string]ChangeKind{"insert.csv": Insert, "update.csv": Update, "delete.csv": Delete}
+
+// one day of a diff
+type Change struct {
+	Kind    ChangeKind
+	Id      string
+	Date    string // YYYYMMDD
+	Element string
+	Value   int
+	Mflag   byte
+	Qflag   byte
+	Sflag   byte
+}
+
+type Diff struct {
+	Name     string
+	From, To string // YYYYMMDD
+	Changes  []Change
+}
+
+// from and to dates of a diff file name
+func ParseDiffName(name string) (from, to string, ok bool) {
+	m := diffName.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// diff tarballs in dir sorted by their dates
+func DiffFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read diff dir %s: %w", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		if _, _, ok := ParseDiffName(e.Name()); ok && !e.IsDir() {
+			names = append(names, filepath.Join(dir, e.Name()))
+		}
+	}
+	slices.Sort(names) // the dates sort as text
+	return names, nil
+}
+
+// read a diff tarball, a *ParseError has the csv file and line
+func ReadDiff(filename string) (*Diff, error) {
+	from, to, ok := ParseDiffName(filename)
+	if !ok {
+		return nil, fmt.Errorf("not a superghcnd diff file name: %s", filename)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open diff %s: %w", filename, err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read diff %s: %w", filename, err)
+	}
+
+	diff := &Diff{Name: filepath.Base(filename), From: from, To: to}
+	found := 0
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read diff %s: %w", filename, err)
+		}
+		kind, ok := changeFiles[path.Base(hdr.Name)]
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		found++
+		if diff.Changes, err = readChanges(tr, kind, diff.Changes); err != nil {
+			var perr *ParseError
+			if errors.As(err, &perr) {
+				perr.File = filename + ":" + hdr.Name
+			}
+			return nil, err
+		}
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("diff %s has no insert, update or delete csv", filename)
+	}
+	return diff, nil
+}
+
+func readChanges(r io.Reader, kind ChangeKind, changes []Change) ([]Change, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	for line := 1; ; line++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return changes, nil
+		}
+		if err != nil {
+			return nil, &ParseError{Line: line, Err: err}
+		}
+		if len(fields) < 7 || len(fields[0]) != 11 || len(fields[1]) != 8 || len(fields[2]) != 4 {
+			return nil, &ParseError{Line: line, Err: ErrShortLine}
+		}
+		if month, err := strconv.Atoi(fields[1][4:6]); err != nil || month < 1 || month > 12 {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("bad date %s", fields[1])}
+		}
+		if day, err := strconv.Atoi(fields[1][6:]); err != nil || day < 1 || day > 31 {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("bad date %s", fields[1])}
+		}
+		value, err := strconv.Atoi(fields[3])
+		if err != nil || value < -9999 || value > 99999 {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("bad value %s", fields[3])}
+		}
+		changes = append(changes, Change{Kind: kind, Id: fields[0], Date: fields[1], Element: fields[2], Value: value,
+			Mflag: flag(fields[4]), Qflag: flag(fields[5]), Sflag: flag(fields[6])})
+	}
+}
+
+// the 270 byte .dly line of dr
+func (dr *DailyRaw) AppendDly(buf []byte) []byte {
+	buf = append(buf, dr.id[:]...)
+	buf = append(buf, dr.year[:]...)
+	buf = append(buf, dr.month[:]...)
+	buf = append(buf, dr.element[:]...)
+	for _, item := range dr.items {
+		buf = append(buf, item.value[:]...)
+		buf = append(buf, item.mflag, item.qflag, item.sflag)
+	}
+	return append(buf, '\n')
+}
+
+// the date the dir is current to, "" if no diff was applied
+func LastApplied(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, AppliedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", AppliedFile, err)
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) < 2 {
+		return "", fmt.Errorf("malformed %s", AppliedFile)
+	}
+	return lines[len(lines)-2], nil
+}
+
+type UpdateOptions struct {
+	AllowGap bool // apply diffs not starting at the last applied date
+	DryRun   bool // check and count without writing
+}
+
+type UpdateResult struct {
+	Applied  []string // diff files applied
+	Skipped  []string // diff files already applied
+	Stations int      // station files written
+	Inserted int
+	Updated  int
+	Deleted  int
+	Missing  int // updates of days not in the dir, inserted; deletes of absent days, ignored
+	Current  string
+}
+
+// apply the diff files in date order to the .dly files of dir, skipping
+// those ending at or before the last applied date
+func ApplyDiffs(dir string, diffs []string, opts UpdateOptions) (UpdateResult, error) {
+	var res UpdateResult
+	last, err := LastApplied(dir)
+	if err != nil {
+		return res, err
+	}
+	res.Current = last
+
+	// a dry run keeps the files it would write for the next diffs
+	var staged map[string][]byte
+	if opts.DryRun {
+		staged = map[string][]byte{}
+	}
+
+	diffs = slices.Clone(diffs)
+	slices.SortFunc(diffs, func(a, b string) int { return strings.Compare(filepath.Base(a), filepath.Base(b)) })
+	for _, name := range diffs {
+		from, to, ok := ParseDiffName(name)
+		if !ok {
+			return res, fmt.Errorf("not a superghcnd diff file name: %s", name)
+		}
+		if to <= res.Current {
+			res.Skipped = append(res.Skipped, name)
+			continue
+		}
+		if res.Current != "" && from != res.Current && !opts.AllowGap {
+			return res, fmt.Errorf("%s from %s, last applied %s: %w", filepath.Base(name), from, res.Current, ErrDiffGap)
+		}
+
+		diff, err := ReadDiff(name)
+		if err != nil {
+			return res, err
+		}
+		if err := applyDiff(dir, diff, staged, &res); err != nil {
+			return res, err
+		}
+		if !opts.DryRun {
+			if err := appendApplied(dir, diff); err != nil {
+				return res, err
+			}
+		}
+		res.Applied = append(res.Applied, name)
+		res.Current = to
+	}
+	return res, nil
+}
+
+func appendApplied(dir string, diff *Diff) error {
+	file, err := os.OpenFile(filepath.Join(dir, AppliedFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", AppliedFile, err)
+	}
+	if _, err := fmt.Fprintf(file, "%s %s\n", diff.To, diff.Name); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", AppliedFile, err)
+	}
+	return file.Close()
+}
+
+func applyDiff(dir string, diff *Diff, staged map[string][]byte, res *UpdateResult) error {
+	byStation := map[string][]Change{}
+	for _, c := range diff.Changes {
+		byStation[c.Id] = append(byStation[c.Id], c)
+	}
+	for _, id := range slices.Sorted(maps.Keys(byStation)) {
+		if err := applyStation(dir, id, byStation[id], staged, res); err != nil {
+			return fmt.Errorf("failed to apply %s to %s: %w", diff.Name, id, err)
+		}
+	}
+	return nil
+}
+
+// apply the changes of a station to its .dly file, written to a temp file,
+// verified and renamed over the original. a dry run (staged not nil) reads
+// and keeps the file contents in staged instead
+func applyStation(dir, id string, changes []Change, staged map[string][]byte, res *UpdateResult) error {
+	name := filepath.Join(dir, id+".dly")
+	var records []DailyRaw
+	data, ok := staged[id]
+	var err error
+	if !ok {
+		data, err = os.ReadFile(name)
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist): // a new station
+	case err != nil:
+		return err
+	default:
+		if records, err = NewDailiesRaw(data); err != nil {
+			var perr *ParseError
+			if errors.As(err, &perr) {
+				perr.File = name
+			}
+			return err
+		}
+	}
+
+	index := map[string]int{} // yyyymm + element -> record
+	for i := range records {
+		index[string(records[i].year[:])+string(records[i].month[:])+records[i].Element()] = i
+	}
+	added := false
+	for _, c := range changes {
+		key := c.Date[:6] + c.Element
+		day, _ := strconv.Atoi(c.Date[6:])
+		i, ok := index[key]
+		present := ok && records[i].items[day-1].present()
+		switch {
+		case c.Kind == Delete && !present:
+			res.Missing++
+			continue
+		case c.Kind == Update && !present:
+			res.Missing++
+		}
+		if !ok {
+			i = len(records)
+			index[key] = i
+			records = append(records, newDailyRaw(id, c.Date[:4], c.Date[4:6], c.Element))
+			added = true
+		}
+		switch c.Kind {
+		case Insert:
+			records[i].setItem(day-1, c.Value, c.Mflag, c.Qflag, c.Sflag)
+			res.Inserted++
+		case Update:
+			records[i].setItem(day-1, c.Value, c.Mflag, c.Qflag, c.Sflag)
+			res.Updated++
+		case Delete:
+			records[i].setItem(day-1, missing, ' ', ' ', ' ')
+			res.Deleted++
+		}
+	}
+
+	// drop emptied records, new ones go after the elements of their month
+	records = slices.DeleteFunc(records, func(dr DailyRaw) bool {
+		return !slices.ContainsFunc(dr.items[:], ItemsRaw.present)
+	})
+	if added {
+		slices.SortStableFunc(records, func(a, b DailyRaw) int {
+			return strings.Compare(string(a.year[:])+string(a.month[:]), string(b.year[:])+string(b.month[:]))
+		})
+	}
+
+	out := make([]byte, 0, len(records)*szDly)
+	for i := range records {
+		out = records[i].AppendDly(out)
+	}
+	if err := verifyDly(id, out, len(records)); err != nil {
+		return err
+	}
+	res.Stations++
+	if staged != nil {
+		staged[id] = out
+		return nil
+	}
+	if len(records) == 0 {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(dir, id+".dly.*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+const szDly = 11 + 4 + 2 + 4 + 31*8 + 1
+
+// out parses back to n records of station id that encode to out
+func verifyDly(id string, out []byte, n int) error {
+	records, err := NewDailiesRaw(out)
+	if err != nil {
+		return fmt.Errorf("updated records don't parse: %w", err)
+	}
+	if len(records) != n || len(out) != n*szDly {
+		return fmt.Errorf("updated file has %d records of %d bytes, want %d", len(records), len(out), n)
+	}
+	var buf []byte
+	for i := range records {
+		if records[i].Id() != id {
+			return fmt.Errorf("record %d of station %s", i+1, records[i].Id())
+		}
+		buf = records[i].AppendDly(buf[:0])
+		if !bytes.Equal(buf, out[i*szDly:(i+1)*szDly]) {
+			return fmt.Errorf("record %d doesn't round trip", i+1)
+		}
+	}
+	return nil
+}
+
+// a value or flag is set
+func (item ItemsRaw) present() bool {
+	value, err := strconv.Atoi(strings.TrimSpace(string(item.value[:])))
+	return (err == nil && value != missing) || item.mflag != ' ' || item.qflag != ' ' || item.sflag != ' '
+}
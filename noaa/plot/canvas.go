@@ -0,0 +1,207 @@
+// warming stripes and the equirectangular station map, drawn on a
+// chart.Renderer so both save as png or svg
+
+package plot
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+
+	"noaa/noaa"
+
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+func fillRect(r chart.Renderer, x0, y0, x1, y1 int, color drawing.Color) {
+	r.SetFillColor(color)
+	r.SetStrokeColor(color)
+	r.SetStrokeWidth(0)
+	r.MoveTo(x0, y0)
+	r.LineTo(x1, y0)
+	r.LineTo(x1, y1)
+	r.LineTo(x0, y1)
+	r.Close()
+	r.Fill()
+}
+
+func setFont(r chart.Renderer, size float64, color drawing.Color) error {
+	font, err := chart.GetDefaultFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
+	}
+	r.SetFont(font)
+	r.SetFontSize(size)
+	r.SetFontColor(color)
+	return nil
+}
+
+// one vertical stripe per year colored by its anomaly, NaN years are grey
+type Stripes struct {
+	Years     []float64
+	Anomalies []float64
+	Limit     float64 // anomaly of the darkest colors, max |anomaly| if 0
+	Width     int     // 1000 if 0
+	Height    int     // 250 if 0
+	Label     string  // drawn in the lower left corner if not empty
+}
+
+func NewStripes(years, anomalies []float64) Stripes {
+	return Stripes{Years: years, Anomalies: anomalies}
+}
+
+func (s Stripes) Render(rp chart.RendererProvider, w io.Writer) error {
+	width, height := cmp.Or(s.Width, 1000), cmp.Or(s.Height, 250)
+	limit := s.Limit
+	if limit == 0 {
+		for _, a := range s.Anomalies {
+			if !math.IsNaN(a) {
+				limit = math.Max(limit, math.Abs(a))
+			}
+		}
+	}
+	if limit == 0 {
+		limit = 1
+	}
+
+	r, err := rp(width, height)
+	if err != nil {
+		return err
+	}
+	// stripes per calendar year, so missing years keep their slot
+	n := len(s.Years)
+	if n > 0 {
+		first, last := s.Years[0], s.Years[n-1]
+		slots := int(last-first) + 1
+		fillRect(r, 0, 0, width, height, noData)
+		for i, year := range s.Years {
+			slot := int(year - first)
+			x0, x1 := slot*width/slots, (slot+1)*width/slots
+			fillRect(r, x0, 0, x1, height, Diverging(0.5+s.Anomalies[i]/(2*limit)))
+		}
+	}
+	if s.Label != "" {
+		if err := setFont(r, 12, drawing.ColorWhite); err != nil {
+			return err
+		}
+		r.Text(s.Label, 8, height-8)
+	}
+	return r.Save(w)
+}
+
+// stations colored by Value on an equirectangular lon/lat canvas with a
+// graticule, optionally over a gridded field of the same statistic
+type StationMap struct {
+	Title  string
+	Unit   string
+	Points []noaa.GridPoint
+	Grid   *noaa.Grid // drawn under the points if not nil
+	Lo, Hi float64    // color range, the points and grid range if equal
+	Width  int        // 1200 if 0, the height is width/2 plus the color bar
+}
+
+func (m StationMap) colorRange() (float64, float64) {
+	if m.Lo != m.Hi {
+		return m.Lo, m.Hi
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range m.Points {
+		if !math.IsNaN(p.Value) {
+			lo, hi = math.Min(lo, p.Value), math.Max(hi, p.Value)
+		}
+	}
+	if m.Grid != nil {
+		glo, ghi := m.Grid.Range()
+		lo, hi = math.Min(lo, glo), math.Max(hi, ghi)
+	}
+	if math.IsInf(lo, 0) {
+		return 0, 1
+	}
+	if lo == hi {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+func (m StationMap) Render(rp chart.RendererProvider, w io.Writer) error {
+	width := cmp.Or(m.Width, 1200)
+	mapH, top := width/2, 0
+	if m.Title != "" {
+		top = 30
+	}
+	height := top + mapH + 50
+	lo, hi := m.colorRange()
+	color := func(v float64) drawing.Color { return Diverging((v - lo) / (hi - lo)) }
+	px := func(lat, lon float64) (int, int) {
+		return int((lon + 180) / 360 * float64(width)), top + int((90-lat)/180*float64(mapH))
+	}
+
+	r, err := rp(width, height)
+	if err != nil {
+		return err
+	}
+	fillRect(r, 0, 0, width, height, drawing.ColorWhite)
+	fillRect(r, 0, top, width, top+mapH, drawing.ColorFromHex("eef3f8"))
+
+	if g := m.Grid; g != nil {
+		for y := range g.H {
+			for x := range g.W {
+				v := g.Values[y*g.W+x]
+				if math.IsNaN(v) {
+					continue
+				}
+				lat, lon := g.Cell(x, y)
+				dlat, dlon := (g.MaxLat-g.MinLat)/float64(g.H)/2, (g.MaxLon-g.MinLon)/float64(g.W)/2
+				x0, y0 := px(lat+dlat, lon-dlon)
+				x1, y1 := px(lat-dlat, lon+dlon)
+				fillRect(r, x0, y0, max(x1, x0+1), max(y1, y0+1), color(v).WithAlpha(180))
+			}
+		}
+	}
+
+	// graticule every 30°
+	r.SetStrokeColor(drawing.ColorFromHex("b0b8c0"))
+	r.SetStrokeWidth(0.5)
+	for lon := -180.0; lon <= 180; lon += 30 {
+		x0, y0 := px(90, lon)
+		x1, y1 := px(-90, lon)
+		r.MoveTo(x0, y0)
+		r.LineTo(x1, y1)
+		r.Stroke()
+	}
+	for lat := -90.0; lat <= 90; lat += 30 {
+		x0, y0 := px(lat, -180)
+		x1, y1 := px(lat, 180)
+		r.MoveTo(x0, y0)
+		r.LineTo(x1, y1)
+		r.Stroke()
+	}
+
+	for _, p := range m.Points {
+		x, y := px(p.Latitude, p.Longitude)
+		r.SetFillColor(color(p.Value))
+		r.SetStrokeColor(drawing.ColorFromHex("333333"))
+		r.SetStrokeWidth(0.75)
+		r.Circle(4, x, y)
+		r.FillStroke()
+	}
+
+	// color bar with its range
+	bar := chart.Box{Left: width / 4, Right: 3 * width / 4, Top: top + mapH + 12, Bottom: top + mapH + 24}
+	for x := bar.Left; x < bar.Right; x++ {
+		fillRect(r, x, bar.Top, x+1, bar.Bottom, Diverging(float64(x-bar.Left)/float64(bar.Width())))
+	}
+	if err := setFont(r, 11, drawing.ColorBlack); err != nil {
+		return err
+	}
+	loText, hiText := fmt.Sprintf("%.1f %s", lo, m.Unit), fmt.Sprintf("%.1f %s", hi, m.Unit)
+	r.Text(loText, bar.Left-r.MeasureText(loText).Width()-8, bar.Bottom)
+	r.Text(hiText, bar.Right+8, bar.Bottom)
+	if m.Title != "" {
+		r.SetFontSize(14)
+		r.Text(m.Title, (width-r.MeasureText(m.Title).Width())/2, 20)
+	}
+	return r.Save(w)
+}
@@ -0,0 +1,321 @@
+// charts of station series: time series with gaps, climatology bands,
+// anomaly bars, warming stripes and station maps, saved as png or svg
+
+package plot
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"noaa/climate"
+
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+// chart.Chart, Stripes, StationMap
+type Renderable interface {
+	Render(rp chart.RendererProvider, w io.Writer) error
+}
+
+// render p to filename, svg for a .svg extension otherwise png
+func Save(p Renderable, filename string) error {
+	rp := chart.PNG
+	if strings.EqualFold(filepath.Ext(filename), ".svg") {
+		rp = chart.SVG
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+	if err := p.Render(rp, file); err != nil {
+		return fmt.Errorf("failed to render %s: %w", filename, err)
+	}
+	return nil
+}
+
+// the warming stripes palette, dark blue to dark red
+var stripeColors = []drawing.Color{
+	drawing.ColorFromHex("08306b"), drawing.ColorFromHex("08519c"), drawing.ColorFromHex("2171b5"), drawing.ColorFromHex("4292c6"),
+	drawing.ColorFromHex("6baed6"), drawing.ColorFromHex("9ecae1"), drawing.ColorFromHex("c6dbef"), drawing.ColorFromHex("deebf7"),
+	drawing.ColorFromHex("fee0d2"), drawing.ColorFromHex("fcbba1"), drawing.ColorFromHex("fc9272"), drawing.ColorFromHex("fb6a4a"),
+	drawing.ColorFromHex("ef3b2c"), drawing.ColorFromHex("cb181d"), drawing.ColorFromHex("a50f15"), drawing.ColorFromHex("67000d"),
+}
+
+var (
+	warm   = drawing.ColorFromHex("cb181d")
+	cold   = drawing.ColorFromHex("2171b5")
+	noData = drawing.ColorFromHex("bbbbbb")
+)
+
+// color of t in 0..1 on the stripes palette, interpolated
+func Diverging(t float64) drawing.Color {
+	if math.IsNaN(t) {
+		return noData
+	}
+	t = math.Max(0, math.Min(1, t)) * float64(len(stripeColors)-1)
+	i := min(int(t), len(stripeColors)-2)
+	f := t - float64(i)
+	a, b := stripeColors[i], stripeColors[i+1]
+	mix := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + f*(float64(y)-float64(x)))) }
+	return drawing.Color{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 255}
+}
+
+// runs of dates no more than maxGap apart, NaN values end a run too
+func segments(dates []time.Time, values []float64, maxGap time.Duration) [][2]int {
+	var runs [][2]int
+	start := -1
+	for i := range dates {
+		if math.IsNaN(values[i]) {
+			if start >= 0 {
+				runs = append(runs, [2]int{start, i})
+			}
+			start = -1
+			continue
+		}
+		if start >= 0 && dates[i].Sub(dates[i-1]) > maxGap {
+			runs = append(runs, [2]int{start, i})
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		runs = append(runs, [2]int{start, len(dates)})
+	}
+	return runs
+}
+
+// one chart.TimeSeries per run, only the first one named for the legend
+func gapSeries(name string, dates []time.Time, values []float64, maxGap time.Duration, style chart.Style) []chart.Series {
+	var series []chart.Series
+	for _, run := range segments(dates, values, maxGap) {
+		ts := chart.TimeSeries{Style: style, XValues: dates[run[0]:run[1]], YValues: values[run[0]:run[1]]}
+		if len(series) == 0 {
+			ts.Name = name
+		}
+		if run[1]-run[0] == 1 { // a lone day has no line
+			ts.Style.DotWidth, ts.Style.DotColor = 1.5, style.StrokeColor
+		}
+		series = append(series, ts)
+	}
+	return series
+}
+
+// chart.Legend of the named series, runs after the first are unnamed
+func legend(c *chart.Chart) chart.Renderable {
+	return func(r chart.Renderer, canvasBox chart.Box, defaults chart.Style) {
+		named := *c
+		named.Series = nil
+		for _, s := range c.Series {
+			if s.GetName() != "" {
+				named.Series = append(named.Series, s)
+			}
+		}
+		chart.Legend(&named)(r, canvasBox, defaults)
+	}
+}
+
+func lineStyle(color drawing.Color, width float64) chart.Style {
+	return chart.Style{StrokeColor: color, StrokeWidth: width}
+}
+
+// daily series, gaps longer than maxGap break the line, 0 for one day
+func TimeSeries(title string, s *climate.DailySeries, maxGap time.Duration) chart.Chart {
+	if maxGap == 0 {
+		maxGap = 24 * time.Hour
+	}
+	return chart.Chart{
+		Title:  title,
+		Width:  1200,
+		Height: 400,
+		XAxis:  chart.XAxis{ValueFormatter: chart.TimeValueFormatterWithFormat("2006-01")},
+		YAxis:  chart.YAxis{Name: fmt.Sprintf("%s %s", s.Element, s.Unit)},
+		Series: gapSeries(s.Element, s.Dates, s.Values, maxGap, lineStyle(chart.GetDefaultColor(0), 1)),
+	}
+}
+
+func yearDate(year float64) time.Time {
+	return time.Date(int(year), 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// annual values, missing years break the line, with a trend line if not nil
+func Annual(title, name string, years, values []float64, trend *climate.Trend) chart.Chart {
+	dates := make([]time.Time, len(years))
+	for i, year := range years {
+		dates[i] = yearDate(year)
+	}
+	c := chart.Chart{
+		Title:      title,
+		Width:      1000,
+		Height:     400,
+		Background: chart.Style{Padding: chart.Box{Top: 40}},
+		XAxis:      chart.XAxis{ValueFormatter: chart.TimeValueFormatterWithFormat("2006")},
+		YAxis:      chart.YAxis{Name: name},
+		Series:     gapSeries(name, dates, values, 366*24*time.Hour, lineStyle(chart.GetDefaultColor(0), 2)),
+	}
+	if trend != nil && len(years) > 1 {
+		first, last := years[0], years[len(years)-1]
+		c.Series = append(c.Series, chart.TimeSeries{
+			Name:    fmt.Sprintf("%s trend %+.2f/decade", trend.Method, trend.PerDecade()),
+			Style:   chart.Style{StrokeColor: warm, StrokeWidth: 1.5, StrokeDashArray: []float64{6, 4}},
+			XValues: []time.Time{yearDate(first), yearDate(last)},
+			YValues: []float64{trend.At(first), trend.At(last)},
+		})
+	}
+	c.Elements = []chart.Renderable{legend(&c)}
+	return c
+}
+
+// band between lower and upper
+type bandSeries struct {
+	name         string
+	style        chart.Style
+	x            []float64
+	lower, upper []float64
+}
+
+func (b bandSeries) GetName() string                { return b.name }
+func (b bandSeries) GetStyle() chart.Style          { return b.style }
+func (b bandSeries) GetYAxis() chart.YAxisType      { return chart.YAxisPrimary }
+func (b bandSeries) Len() int                       { return len(b.x) }
+func (b bandSeries) GetValues(i int) (x, y float64) { return b.x[i], (b.lower[i] + b.upper[i]) / 2 }
+func (b bandSeries) GetBoundedValues(i int) (x, y1, y2 float64) {
+	return b.x[i], b.upper[i], b.lower[i]
+}
+
+func (b bandSeries) Validate() error {
+	if len(b.x) == 0 || len(b.lower) != len(b.x) || len(b.upper) != len(b.x) {
+		return fmt.Errorf("band %s: x, lower and upper lengths differ or are 0", b.name)
+	}
+	return nil
+}
+
+func (b bandSeries) Render(r chart.Renderer, canvasBox chart.Box, xrange, yrange chart.Range, defaults chart.Style) {
+	chart.Draw.BoundedSeries(r, canvasBox, xrange, yrange, b.style.InheritFrom(defaults), b)
+}
+
+// calendar date of t in the leap year 2000
+func calendarDate(t time.Time) time.Time {
+	return time.Date(2000, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// daily normal with its ±σ band over the calendar year, and the days of
+// year overlaid when not nil
+func Climatology(title string, n climate.Normals, unit string, year *climate.DailySeries) chart.Chart {
+	var dates []time.Time
+	var normal, lower, upper []float64
+	for cd := range n.Daily {
+		dates = append(dates, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, cd))
+		normal = append(normal, n.Daily[cd])
+		lower = append(lower, n.Daily[cd]-n.DailyStd[cd])
+		upper = append(upper, n.Daily[cd]+n.DailyStd[cd])
+	}
+
+	c := chart.Chart{
+		Title:      title,
+		Width:      1000,
+		Height:     450,
+		Background: chart.Style{Padding: chart.Box{Top: 40}},
+		XAxis:      chart.XAxis{ValueFormatter: chart.TimeValueFormatterWithFormat("Jan")},
+		YAxis:      chart.YAxis{Name: fmt.Sprintf("%s %s", n.Element, unit)},
+	}
+	bandColor := drawing.ColorFromHex("9ecae1").WithAlpha(140)
+	band := chart.Style{FillColor: bandColor, StrokeColor: bandColor, StrokeWidth: 1}
+	for _, run := range segments(dates, normal, 24*time.Hour) {
+		x := make([]float64, 0, run[1]-run[0])
+		for _, t := range dates[run[0]:run[1]] {
+			x = append(x, chart.TimeToFloat64(t))
+		}
+		name := ""
+		if len(c.Series) == 0 {
+			name = "±σ"
+		}
+		c.Series = append(c.Series, bandSeries{name: name, style: band, x: x,
+			lower: lower[run[0]:run[1]], upper: upper[run[0]:run[1]]})
+	}
+	name := fmt.Sprintf("normal %d-%d", n.From, n.To)
+	c.Series = append(c.Series, gapSeries(name, dates, normal, 24*time.Hour, lineStyle(cold, 2))...)
+
+	if year != nil && len(year.Dates) > 0 {
+		days := make([]time.Time, len(year.Dates))
+		for i, t := range year.Dates {
+			days[i] = calendarDate(t)
+		}
+		c.Series = append(c.Series, gapSeries(year.Dates[0].Format("2006"), days, year.Values, 24*time.Hour, lineStyle(warm, 1))...)
+	}
+	c.Elements = []chart.Renderable{legend(&c)}
+	return c
+}
+
+// bars from 0, warm above and cold below
+type barSeries struct {
+	x, y []float64
+}
+
+func (b barSeries) GetName() string                { return "anomaly" }
+func (b barSeries) GetStyle() chart.Style          { return chart.Style{} }
+func (b barSeries) GetYAxis() chart.YAxisType      { return chart.YAxisPrimary }
+func (b barSeries) Len() int                       { return len(b.x) }
+func (b barSeries) GetValues(i int) (x, y float64) { return b.x[i], b.y[i] }
+
+func (b barSeries) Validate() error {
+	if len(b.x) != len(b.y) {
+		return fmt.Errorf("anomaly bars: x and y lengths differ")
+	}
+	return nil
+}
+
+func (b barSeries) Render(r chart.Renderer, canvasBox chart.Box, xrange, yrange chart.Range, defaults chart.Style) {
+	width := max(1, int(0.8*float64(xrange.GetDomain())/float64(max(1, len(b.x)))))
+	zero := canvasBox.Bottom - yrange.Translate(0)
+	for i := range b.x {
+		if math.IsNaN(b.y[i]) {
+			continue
+		}
+		x := canvasBox.Left + xrange.Translate(b.x[i])
+		y := canvasBox.Bottom - yrange.Translate(b.y[i])
+		color := warm
+		if b.y[i] < 0 {
+			color = cold
+		}
+		box := chart.Box{Left: x - width/2, Right: x - width/2 + width, Top: min(y, zero), Bottom: max(y, zero)}
+		chart.Draw.Box(r, box, chart.Style{FillColor: color, StrokeColor: color, StrokeWidth: 0.5})
+	}
+}
+
+// anomaly bars at dates, e.g. of climate.MonthlyAnomalies
+func AnomalyBars(title, name string, dates []time.Time, anomalies []float64) chart.Chart {
+	x := make([]float64, len(dates))
+	lo, hi := 0.0, 0.0
+	for i, t := range dates {
+		x[i] = chart.TimeToFloat64(t)
+		if !math.IsNaN(anomalies[i]) {
+			lo, hi = math.Min(lo, anomalies[i]), math.Max(hi, anomalies[i])
+		}
+	}
+	pad := (hi - lo) * 0.05
+	if pad == 0 {
+		pad = 1
+	}
+	format := "2006-01"
+	if len(dates) > 1 && dates[len(dates)-1].Sub(dates[0]) > 10*365*24*time.Hour {
+		format = "2006"
+	}
+	return chart.Chart{
+		Title:      title,
+		Width:      1000,
+		Height:     400,
+		Background: chart.Style{Padding: chart.Box{Top: 40}},
+		XAxis:      chart.XAxis{ValueFormatter: chart.TimeValueFormatterWithFormat(format)},
+		YAxis:      chart.YAxis{Name: name, Range: &chart.ContinuousRange{Min: lo - pad, Max: hi + pad}},
+		Series:     []chart.Series{barSeries{x: x, y: anomalies}},
+	}
+}
@@ -0,0 +1,445 @@
+// endpoints and their json/csv encoding
+
+package server
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"noaa/noaa"
+)
+
+// a list item with its csv header and fields, json from the struct tags
+type row interface {
+	header() []string
+	fields() []string
+}
+
+type page[T row] struct {
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Next   string `json:"next,omitempty"`
+	Items  []T    `json:"items"`
+}
+
+func wantsCSV(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	enc.Encode(v)
+}
+
+// int query parameter, def if absent
+func intParam(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, badRequest("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+func floatParam(q url.Values, name string, def float64) (float64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, badRequest("invalid %s %q", name, v)
+	}
+	return f, nil
+}
+
+// url of r at offset
+func pageURL(r *http.Request, offset int) string {
+	q := r.URL.Query()
+	q.Set("offset", strconv.Itoa(offset))
+	return (&url.URL{Path: r.URL.Path, RawQuery: q.Encode()}).String()
+}
+
+// the offset & limit page of items as json or csv, X-Total-Count and Link
+// headers for both
+func writePage[T row](s *Server, w http.ResponseWriter, r *http.Request, items []T) {
+	q := r.URL.Query()
+	offset, err := intParam(q, "offset", 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	limit, err := intParam(q, "limit", s.opts.PageSize)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if offset < 0 || limit < 1 || limit > s.opts.MaxPageSize {
+		writeError(w, badRequest("offset must be >= 0 and limit in 1..%d", s.opts.MaxPageSize))
+		return
+	}
+
+	// empty past the end, offset+limit may overflow
+	start := min(offset, len(items))
+	end := start + min(limit, len(items)-start)
+	p := page[T]{Total: len(items), Offset: offset, Limit: limit, Items: items[start:end]}
+	var links []string
+	if end < len(items) {
+		p.Next = pageURL(r, end)
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, p.Next))
+	}
+	if offset > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, max(0, offset-limit))))
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+	if links != nil {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	if !wantsCSV(r) {
+		if p.Items == nil {
+			p.Items = []T{}
+		}
+		writeJSON(w, http.StatusOK, p)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	var zero T
+	cw.Write(zero.header())
+	for _, item := range p.Items {
+		cw.Write(item.fields())
+	}
+	cw.Flush()
+}
+
+func ftoa(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// physical values without the float noise of the scaling, e.g. 343 * 0.1
+func round(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// stations
+
+type stationItem struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Country   string   `json:"country"`
+	State     string   `json:"state,omitempty"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Elevation float64  `json:"elevation"`
+	Distance  *float64 `json:"distance_km,omitempty"`
+}
+
+func (stationItem) header() []string {
+	return []string{"id", "name", "country", "state", "latitude", "longitude", "elevation", "distance_km"}
+}
+
+func (it stationItem) fields() []string {
+	distance := ""
+	if it.Distance != nil {
+		distance = strconv.FormatFloat(*it.Distance, 'f', 1, 64)
+	}
+	return []string{it.Id, it.Name, it.Country, it.State, ftoa(it.Latitude), ftoa(it.Longitude), ftoa(it.Elevation), distance}
+}
+
+func newStationItem(st noaa.Station) stationItem {
+	return stationItem{Id: st.Id, Name: strings.TrimSpace(st.Name), Country: st.Id[:min(2, len(st.Id))],
+		State: strings.TrimSpace(st.State), Latitude: st.Latitude, Longitude: st.Longitude, Elevation: st.Elevation}
+}
+
+// station query of the country, state, station, near, radius & n parameters
+func stationQuery(q url.Values) (noaa.StationQuery, error) {
+	sq := noaa.StationQuery{Country: q.Get("country"), State: q.Get("state")}
+	if ids := q.Get("station"); ids != "" {
+		sq.Ids = strings.Split(ids, ",")
+	}
+	if near := q.Get("near"); near != "" {
+		lat, lon, ok := strings.Cut(near, ",")
+		var err1, err2 error
+		sq.Lat, err1 = strconv.ParseFloat(lat, 64)
+		sq.Lon, err2 = strconv.ParseFloat(lon, 64)
+		if !ok || err1 != nil || err2 != nil {
+			return sq, badRequest("invalid near %q, lat,lon", near)
+		}
+		sq.Near = true
+	}
+	var err error
+	if sq.Radius, err = floatParam(q, "radius", 100); err != nil {
+		return sq, err
+	}
+	if sq.Nearest, err = intParam(q, "n", 0); err != nil {
+		return sq, err
+	}
+	return sq, nil
+}
+
+func (s *Server) selectStations(q url.Values) ([]noaa.StationDistance, error) {
+	sq, err := stationQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := s.db.SelectStations(sq)
+	if err != nil {
+		return nil, &httpError{http.StatusBadRequest, err}
+	}
+	return selected, nil
+}
+
+func (s *Server) stations(w http.ResponseWriter, r *http.Request) {
+	selected, err := s.selectStations(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	items := make([]stationItem, len(selected))
+	for i, sd := range selected {
+		items[i] = newStationItem(sd.Station)
+		if r.URL.Query().Get("near") != "" {
+			items[i].Distance = &sd.Distance
+		}
+	}
+	writePage(s, w, r, items)
+}
+
+type inventoryItem struct {
+	Element   string `json:"element"`
+	FirstYear int    `json:"first_year"`
+	LastYear  int    `json:"last_year"`
+}
+
+type stationDetail struct {
+	stationItem
+	CountryName string          `json:"country_name"`
+	Gsn         bool            `json:"gsn"`
+	HcnCrn      string          `json:"hcn_crn,omitempty"`
+	WmoId       string          `json:"wmo_id,omitempty"`
+	Inventory   []inventoryItem `json:"inventory"`
+}
+
+// station with its inventory, json only
+func (s *Server) station(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	st, ok := s.db.Stations[id]
+	if !ok {
+		writeError(w, notFound("unknown station %q", id))
+		return
+	}
+	d := stationDetail{stationItem: newStationItem(st), Gsn: strings.TrimSpace(st.Gsn_flag) != "",
+		HcnCrn: strings.TrimSpace(st.Hcn_crn_flag), WmoId: strings.TrimSpace(st.Wmo_id), Inventory: []inventoryItem{}}
+	d.CountryName = s.db.Countries[d.Country]
+	for _, item := range s.db.Inventory[id] {
+		d.Inventory = append(d.Inventory, inventoryItem{item.Element, item.FirstYear, item.LastYear})
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// series
+
+type dayItem struct {
+	Station string  `json:"station"`
+	Element string  `json:"element"`
+	Date    string  `json:"date"`
+	Value   float64 `json:"value"`
+	Unit    string  `json:"unit,omitempty"`
+	Mflag   string  `json:"mflag,omitempty"`
+	Qflag   string  `json:"qflag,omitempty"`
+	Sflag   string  `json:"sflag,omitempty"`
+}
+
+func (dayItem) header() []string {
+	return []string{"station", "element", "date", "value", "unit", "mflag", "qflag", "sflag"}
+}
+
+func (it dayItem) fields() []string {
+	return []string{it.Station, it.Element, it.Date, ftoa(it.Value), it.Unit, it.Mflag, it.Qflag, it.Sflag}
+}
+
+func flagString(b byte) string {
+	return strings.TrimSpace(string(b))
+}
+
+// element, from, to & reject parameters, reject=none keeps flagged values
+func (s *Server) recordParams(q url.Values) (element string, from, to int, reject string, err error) {
+	element = strings.ToUpper(q.Get("element"))
+	if element == "" {
+		return "", 0, 0, "", badRequest("element is required")
+	}
+	if _, ok := s.db.Elements[element]; !ok {
+		return "", 0, 0, "", badRequest("unknown element %q", element)
+	}
+	if from, err = intParam(q, "from", 0); err != nil {
+		return
+	}
+	if to, err = intParam(q, "to", 0); err != nil {
+		return
+	}
+	reject = noaa.AllQflags
+	if q.Has("reject") {
+		reject = strings.ToUpper(q.Get("reject"))
+		if reject == "NONE" {
+			reject = ""
+		}
+	}
+	return element, from, to, reject, nil
+}
+
+// daily values of one station element
+func (s *Server) series(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := q.Get("station")
+	if id == "" {
+		writeError(w, badRequest("station is required"))
+		return
+	}
+	if _, ok := s.db.Stations[id]; !ok {
+		writeError(w, notFound("unknown station %q", id))
+		return
+	}
+	element, from, to, reject, err := s.recordParams(q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info := s.db.Registry.Info(element)
+	var items []dayItem
+	seq, errf := s.dailies(r.Context(), element, []string{id}, from, to)
+	for dr := range seq {
+		year, month := dr.Year(), dr.Month()
+		for d := range dr.Days() {
+			if value, ok := dr.Valid(d, reject); ok {
+				items = append(items, dayItem{Station: id, Element: element, Date: fmt.Sprintf("%04d-%02d-%02d", year, month, d+1),
+					Value: round(float64(value) * info.Scale), Unit: string(info.Unit),
+					Mflag: flagString(dr.Mflag(d)), Qflag: flagString(dr.Qflag(d)), Sflag: flagString(dr.Sflag(d))})
+			}
+		}
+	}
+	if err := errf(); err != nil {
+		writeError(w, err)
+		return
+	}
+	// the pipeline yields a station's records in file order, not by date
+	slices.SortStableFunc(items, func(a, b dayItem) int { return strings.Compare(a.Date, b.Date) })
+	writePage(s, w, r, items)
+}
+
+// aggregate
+
+type aggregateItem struct {
+	Group    string  `json:"group"`
+	Element  string  `json:"element"`
+	Year     int     `json:"year"`
+	Month    int     `json:"month,omitempty"` // 0 for a year
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Mean     float64 `json:"mean"`
+	Sum      float64 `json:"sum"`
+	Count    int     `json:"count"`    // valid station-days
+	Coverage float64 `json:"coverage"` // valid / calendar station-days
+	Unit     string  `json:"unit,omitempty"`
+}
+
+func (aggregateItem) header() []string {
+	return []string{"group", "element", "year", "month", "min", "max", "mean", "sum", "count", "coverage", "unit"}
+}
+
+func (it aggregateItem) fields() []string {
+	return []string{it.Group, it.Element, strconv.Itoa(it.Year), strconv.Itoa(it.Month), ftoa(it.Min), ftoa(it.Max),
+		ftoa(it.Mean), ftoa(it.Sum), strconv.Itoa(it.Count), ftoa(it.Coverage), it.Unit}
+}
+
+// monthly or yearly stats of the selected stations by station, country or all
+func (s *Server) aggregate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	element, from, to, reject, err := s.recordParams(q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	group := cmp.Or(q.Get("group"), "all")
+	period := cmp.Or(q.Get("period"), "month")
+	if !slices.Contains([]string{"station", "country", "all"}, group) || !slices.Contains([]string{"month", "year"}, period) {
+		writeError(w, badRequest("group is one of station, country, all and period month or year"))
+		return
+	}
+
+	var stations []string // nil for all
+	if q.Has("country") || q.Has("state") || q.Has("station") || q.Has("near") {
+		selected, err := s.selectStations(q)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		stations = []string{}
+		for _, sd := range selected {
+			stations = append(stations, sd.Id)
+		}
+		slices.Sort(stations)
+	}
+
+	type key struct {
+		group       string
+		year, month int
+	}
+	stats := map[key]noaa.Stat{}
+	seq, errf := s.dailies(r.Context(), element, stations, from, to)
+	for dr := range seq {
+		k := key{"all", dr.Year(), dr.Month()}
+		switch group {
+		case "station":
+			k.group = dr.Id()
+		case "country":
+			k.group = dr.Country()
+		}
+		if period == "year" {
+			k.month = 0
+		}
+		stats[k] = stats[k].Merge(dr.Stats(reject))
+	}
+	if err := errf(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info := s.db.Registry.Info(element)
+	var items []aggregateItem
+	for k, st := range stats {
+		if st.Count == 0 {
+			continue
+		}
+		items = append(items, aggregateItem{Group: k.group, Element: element, Year: k.year, Month: k.month,
+			Min: round(float64(st.Min) * info.Scale), Max: round(float64(st.Max) * info.Scale),
+			Mean: round(st.Avg() * info.Scale), Sum: round(st.Sum * info.Scale),
+			Count: st.Count, Coverage: round(st.Coverage()), Unit: string(info.Unit)})
+	}
+	slices.SortFunc(items, func(a, b aggregateItem) int {
+		if c := strings.Compare(a.Group, b.Group); c != 0 {
+			return c
+		}
+		return (a.Year*13 + a.Month) - (b.Year*13 + b.Month)
+	})
+	writePage(s, w, r, items)
+}
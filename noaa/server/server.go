@@ -0,0 +1,197 @@
+// http json/csv api over a NOAA_DB
+//
+//	GET /stations?country=&state=&near=lat,lon&radius=&n=
+//	GET /stations/{id}
+//	GET /series?station=&element=&from=&to=
+//	GET /aggregate?element=&country=&station=&from=&to=&group=station|country|all&period=month|year
+//
+// lists take offset & limit and answer {total, offset, limit, next, items}
+// or csv with format=csv or Accept: text/csv, the page links are in the Link
+// header. responses carry an etag of the dataset version and the request
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"iter"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"noaa/noaa"
+)
+
+type Options struct {
+	Cache        *noaa.Cache // column cache for series and aggregates, the dailies if nil
+	PageSize     int         // default limit, 100 if 0
+	MaxPageSize  int         // max limit, 10000 if 0
+	CacheControl string      // Cache-Control of the responses, "no-cache" (revalidate) if empty
+}
+
+type Server struct {
+	db   *noaa.NOAA_DB
+	opts Options
+	mux  *http.ServeMux
+
+	mu      sync.Mutex
+	stamp   string // of the db and cache when version was computed
+	version string
+}
+
+func New(db *noaa.NOAA_DB, opts Options) (*Server, error) {
+	if opts.PageSize == 0 {
+		opts.PageSize = 100
+	}
+	if opts.MaxPageSize == 0 {
+		opts.MaxPageSize = 10000
+	}
+	if opts.CacheControl == "" {
+		opts.CacheControl = "no-cache"
+	}
+	s := &Server{db: db, opts: opts, mux: http.NewServeMux()}
+	if _, err := s.dataVersion(); err != nil {
+		return nil, err
+	}
+	s.mux.HandleFunc("GET /stations", s.stations)
+	s.mux.HandleFunc("GET /stations/{id}", s.station)
+	s.mux.HandleFunc("GET /series", s.series)
+	s.mux.HandleFunc("GET /aggregate", s.aggregate)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	version, err := s.dataVersion()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	etag := etag(version, r)
+	for m := range strings.SplitSeq(r.Header.Get("If-None-Match"), ",") {
+		if m = strings.TrimSpace(m); m == etag || m == "W/"+etag || m == "*" {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	s.mux.ServeHTTP(&etagWriter{ResponseWriter: w, etag: etag, cacheControl: s.opts.CacheControl}, r)
+}
+
+// version of the db and the cache, recomputed when their stamp changes,
+// e.g. after noaa update applied diffs to the served daily dir
+func (s *Server) dataVersion() (string, error) {
+	stamp, err := s.db.Stamp()
+	if err != nil {
+		return "", err
+	}
+	var cacheVersion string
+	if s.opts.Cache != nil {
+		if cacheVersion, err = s.opts.Cache.Version(); err != nil {
+			return "", err
+		}
+		stamp += "-" + cacheVersion
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stamp != s.stamp {
+		version, err := s.db.Version()
+		if err != nil {
+			return "", err
+		}
+		if cacheVersion != "" {
+			version += "-" + cacheVersion
+		}
+		s.stamp, s.version = stamp, version
+	}
+	return s.version, nil
+}
+
+// strong etag of the dataset version, the path, the sorted query and the format
+func etag(version string, r *http.Request) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\n%s\n%t", r.URL.Path, r.URL.Query().Encode(), wantsCSV(r))
+	return fmt.Sprintf(`"%s-%x"`, version, h.Sum64())
+}
+
+// sets the etag and cache control of 200 responses
+type etagWriter struct {
+	http.ResponseWriter
+	etag         string
+	cacheControl string
+	wroteHeader  bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status == http.StatusOK {
+		w.Header().Set("ETag", w.etag)
+		w.Header().Set("Cache-Control", w.cacheControl)
+		w.Header().Add("Vary", "Accept")
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// an error with its status code
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+func (e *httpError) Unwrap() error { return e.err }
+
+func badRequest(format string, args ...any) error {
+	return &httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...any) error {
+	return &httpError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+// json {"error": ...} with the status of a *httpError, 500 otherwise
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		status = he.status
+	case errors.Is(err, context.Canceled):
+		status = 499 // client closed the request, nobody reads it
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// selected monthly records of element, from the cache when it has the element
+func (s *Server) dailies(ctx context.Context, element string, stations []string, from, to int) (iter.Seq2[noaa.DailyRaw, noaa.Station], func() error) {
+	if s.opts.Cache != nil {
+		if elements, err := s.opts.Cache.Elements(); err == nil && slices.Contains(elements, element) {
+			return s.opts.Cache.Dailies(noaa.CacheQuery{Element: element, Stations: stations, FromYear: from, ToYear: to})
+		}
+	}
+	var ids map[string]bool
+	if stations != nil {
+		ids = map[string]bool{}
+		for _, id := range stations {
+			ids[id] = true
+		}
+	}
+	return s.db.Dailies(ctx, noaa.TraverseOptions{
+		Stations: func(id string) bool { return ids == nil || ids[id] },
+		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
+			year := dr.Year()
+			return dr.Element() == element && (from == 0 || year >= from) && (to == 0 || year <= to)
+		},
+		Ordered: true,
+	})
+}
@@ -1,29 +1,60 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"noaa/climate"
 	"noaa/noaa"
+	"noaa/plot"
+	"noaa/server"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
+	"testing/fstest"
 	"time"
-	"unsafe"
 
 	"github.com/go-gota/gota/dataframe"
-	"github.com/wcharczuk/go-chart/v2"
+	"github.com/go-gota/gota/series"
 )
 
+// db in $NOAA_DATA_PATH or the default data dir
+func openDB() *noaa.NOAA_DB {
+	db, err := noaa.Open(noaa.DataPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+func printProgress(p noaa.Progress) {
+	fmt.Printf("file #: %d, found: %d %d %v\r", p.Files, p.Found, p.Year, p.Id)
+}
+
 func TestOpenDB() {
 	t0 := time.Now()
 
-	db := noaa.OpenDB()
-	fmt.Println("NOAA_DB\nlap to open all aux files into maps: ", time.Since(t0), "\n")
+	db := openDB()
+	fmt.Printf("NOAA_DB\nlap to open all aux files into maps: %v\n\n", time.Since(t0))
 
 	fmt.Printf("Countries: %+v\n", db.Countries["US"])
 	fmt.Printf("States   : %+v\n", db.States["AL"])
 	fmt.Printf("Elements : %+v\n", db.Elements["TMAX"])
 	fmt.Printf("Stations : %+v\n", db.Stations["USW00094728"])
 
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 
 }
 
@@ -37,42 +68,47 @@ func TestGetDailyObs() { // create an array of DailyObsStats and a DataFrame wit
 		Max     int
 		Min     int
 		Avg     float64
+		Days    int // valid days
 	}
 
 	doStat := make([]DailyObsStat, 0)
 
-	db := noaa.OpenDB()
+	db := openDB()
 
-	dt := noaa.DailyTraverse{
+	opts := noaa.TraverseOptions{
 		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
 			return dr.Year() >= 2020 && dr.Element() == "TMAX"
 		},
-		Progress: func(nfile int, dt *noaa.DailyTraverse, dr *noaa.DailyRaw) {
-			fmt.Printf("file #: %d, found: %d %d %v %v\r", nfile, dt.CountFound, dr.Year(), dr.Country(), dr.Id())
-		},
-		FoundFunc: func(dr noaa.DailyRaw, dataPtr unsafe.Pointer) {
-			dos := (*[]DailyObsStat)(dataPtr)
-			*dos = append(*dos, DailyObsStat{
-				Id:      dr.Id(),
-				Country: dr.Country(),
-				Element: dr.Element(),
-				Year:    dr.Year(),
-				Month:   dr.Month(),
-
-				Max:     dr.Max(),
-				Min:     dr.Min(),
-				Avg:     dr.Avg(),
-			})
-		},
-		DataPtr:      unsafe.Pointer(&doStat),
-		ProgressStop: 1000,
-		MaxTraverse:  100000,
-		MaxFound:     100000,
+		Progress:      printProgress,
+		ProgressEvery: 1000,
+		MaxFiles:      100000,
+		MaxFound:      100000,
 	}
 
 	t0 := time.Now()
 
-	noaa.TraverseDaily(db, dt)
+	seq, errf := db.Dailies(context.Background(), opts)
+	for dr := range seq {
+		stat := dr.Stats(noaa.AllQflags)
+		if stat.Count == 0 {
+			continue
+		}
+		doStat = append(doStat, DailyObsStat{
+			Id:      dr.Id(),
+			Country: dr.Country(),
+			Element: dr.Element(),
+			Year:    dr.Year(),
+			Month:   dr.Month(),
+
+			Max:  stat.Max,
+			Min:  stat.Min,
+			Avg:  stat.Avg(),
+			Days: stat.Count,
+		})
+	}
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
 
 	fmt.Printf("\n\nlap to read daily: %v, items: %d\n", time.Since(t0), len(doStat))
 
@@ -92,93 +128,552 @@ func TestGetDailyObs() { // create an array of DailyObsStats and a DataFrame wit
 
 func TestTraverseDailyObs() {
 
-	db := noaa.OpenDB()
+	db := openDB()
 
-	type YearStat struct { // [year]max of tmax
-		yearTMaxMap map[int]int
-	}
-	ym := YearStat{yearTMaxMap: make(map[int]int)}
 	t0 := time.Now()
 
-	dt := noaa.DailyTraverse{
+	opts := noaa.TraverseOptions{
 		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
 			return dr.Year() >= 2000 && dr.Element() == "TMAX"
 		},
-		Progress: func(nfile int, dt *noaa.DailyTraverse, dr *noaa.DailyRaw) {
-			fmt.Printf("file #: %d, found: %d %d %v %v\r", nfile, dt.CountFound, dr.Year(), dr.Country(), dr.Id())
-		},
-		FoundFunc: func(dr noaa.DailyRaw, dataPtr unsafe.Pointer) {
-			if max_temp, ym := dr.Max(), (*YearStat)(dataPtr); max_temp < 750 { // tenths of degrees
-				if tmax, ok := ym.yearTMaxMap[dr.Year()]; ok {
-					ym.yearTMaxMap[dr.Year()] = noaa.MaxInt(tmax, max_temp)
+		Progress:      printProgress,
+		ProgressEvery: 1000,
+		// MaxFiles: 10000,
+		// MaxFound: 1000000,
+	}
+	// [year]max of tmax
+	yearTMaxMap, err := noaa.Traverse(context.Background(), db, opts, make(map[int]int),
+		func(ym map[int]int, dr noaa.DailyRaw, station noaa.Station) map[int]int {
+			if max_temp := dr.Max(); max_temp < 750 { // tenths of degrees
+				if tmax, ok := ym[dr.Year()]; ok {
+					ym[dr.Year()] = noaa.MaxInt(tmax, max_temp)
 				} else {
-					ym.yearTMaxMap[dr.Year()] = max_temp
+					ym[dr.Year()] = max_temp
 				}
 			}
-		},
-		DataPtr:      unsafe.Pointer(&ym),
-		ProgressStop: 1000,
-		// MaxTraverse:  10000,
-		// MaxFound:     1000000,
+			return ym
+		})
+	if err != nil {
+		log.Fatal(err)
 	}
-	noaa.TraverseDaily(db, dt)
 	fmt.Printf("\n\nlap to read daily: %v\n", time.Since(t0))
 
 	// create a sorted array of years and max temps
-	years := make([]float64, 0, len(ym.yearTMaxMap))
-	for year := range ym.yearTMaxMap {
+	years := make([]float64, 0, len(yearTMaxMap))
+	for year := range yearTMaxMap {
 		years = append(years, float64(year))
 	}
 	sort.Float64s(years)
 	max_temps := make([]float64, len(years))
 	for i, temp := range years {
-		max_temps[i] = float64(ym.yearTMaxMap[int(temp)]) / 10.0 // tmax in tenths of degrees
-	}
-
-	// plot chart
-	linearRegression := func(x, y []float64) (lineX, lineY []float64) {
-		n := float64(len(x))
-		var sumX, sumY, sumXY, sumX2 float64
-		for i := 0; i < int(n); i++ {
-			sumX += x[i]
-			sumY += y[i]
-			sumXY += x[i] * y[i]
-			sumX2 += x[i] * x[i]
+		max_temps[i] = db.Registry.Quantity("TMAX", yearTMaxMap[int(temp)]).Value // °C
+	}
+
+	// plot them to chart.png
+	trend := climate.OLS(years, max_temps, 0.95)
+	graph := plot.Annual("global TMAX for year > 2000", "years/TMAX", years, max_temps, &trend)
+	if err := plot.Save(graph, "chart.png"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// synthetic stations in testdata, and a malformed aux file reported with its line
+func TestTestdata() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("testdata stations: %d, elements: %d\n", len(db.Stations), len(db.Elements))
+
+	opts := noaa.TraverseOptions{
+		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
+			return dr.Element() == "TMAX" && station.State == "NY"
+		},
+	}
+	count, err := noaa.Traverse(context.Background(), db, opts, 0,
+		func(n int, dr noaa.DailyRaw, station noaa.Station) int { return n + 1 })
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("NY TMAX months: %d\n", count)
+
+	_, err = noaa.OpenFS(fstest.MapFS{
+		"ghcnd-countries.txt": {Data: []byte("US United States\nX\n")},
+	})
+	var perr *noaa.ParseError
+	fmt.Printf("malformed: %v, typed: %v\n", err, errors.As(err, &perr))
+}
+
+// ingest testdata into a columnar cache then query TMAX for Spain since 2000
+// from the cache and by a full traversal
+func TestCache() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "noaa-cache")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Now()
+	if err := noaa.Ingest(context.Background(), db, dir, noaa.TraverseOptions{}); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("ingest: %v\n", time.Since(t0))
+
+	cache, err := noaa.OpenCache(db, dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cache.Close()
+
+	type total struct {
+		records, days int
+		sum           int64
+	}
+	add := func(t total, dr noaa.DailyRaw) total {
+		for d := range 31 {
+			if v := dr.Value(d); v != 0 {
+				t.sum += int64(v)
+				t.days++
+			}
 		}
+		t.records++
+		return t
+	}
+
+	t0 = time.Now()
+	cached := total{}
+	seq, errf := cache.Dailies(noaa.CacheQuery{Element: "TMAX", Country: "SP", FromYear: 2000})
+	for dr := range seq {
+		cached = add(cached, dr)
+	}
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("cache query: %v %+v\n", time.Since(t0), cached)
+
+	t0 = time.Now()
+	opts := noaa.TraverseOptions{
+		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
+			return dr.Element() == "TMAX" && dr.Year() >= 2000
+		},
+		Stations: func(id string) bool { return strings.HasPrefix(id, "SP") },
+	}
+	scanned, err := noaa.Traverse(context.Background(), db, opts, total{},
+		func(t total, dr noaa.DailyRaw, station noaa.Station) total { return add(t, dr) })
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("scan: %v %+v\n", time.Since(t0), scanned)
 
-		m := (n*sumXY - sumX*sumY) / (n*sumX2 - sumX*sumX)
-		b := (sumY / n) - (m * sumX / n)
+	hot, err := cache.Partitions(noaa.CacheQuery{Element: "TMAX", Where: func(p noaa.Partition) bool { return p.Max >= 400 }})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("station-years reaching 40C: %d, inventory TMAX stations: %v\n", len(hot), db.InventoryStations("TMAX", "", 2000, 0))
+}
+
+// physical values and derived variables of one station-month
+func TestElements() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmax := db.Registry.Quantity("TMAX", 253)
+	tmaxF, _ := tmax.In(noaa.Fahrenheit)
+	prcp, _ := db.Registry.Quantity("PRCP", 254).In(noaa.Inch)
+	fmt.Printf("TMAX %v = %v, PRCP %v\n", tmax, tmaxF, prcp)
 
-		lineX = make([]float64, len(x))
-		lineY = make([]float64, len(y))
-		for i := 0; i < len(x); i++ {
-			lineX[i] = x[i]
-			lineY[i] = m*x[i] + b
+	// descriptions without a unit fall back to the known units, snow is not a soil temperature
+	bare := noaa.NewRegistry(map[string]string{"SNOW": "Snowfall", "SNWD": "Snow depth", "SN32": "Minimum soil temperature"})
+	for i, code := range []string{"SNOW", "SNWD", "SN32"} {
+		info, want := bare.Info(code), []noaa.Unit{noaa.Millimeter, noaa.Millimeter, noaa.Celsius}[i]
+		fmt.Println(info)
+		if info.Unit != want {
+			log.Fatalf("%s unit %q, want %q", code, info.Unit, want)
 		}
-		return lineX, lineY
 	}
-	lineX, lineY := linearRegression(years, max_temps)
 
-	// plot them to chart.png
-	graph := chart.Chart{
-		Title: "global TMAX for year > 2000",
-		Series: []chart.Series{
-			chart.ContinuousSeries{
-				Name:    "years/TMAX",
-				XValues: years,
-				YValues: max_temps,
-			},
-			chart.ContinuousSeries{
-				Name:    "Linear Regression",
-				XValues: lineX,
-				YValues: lineY,
-			},
+	opts := noaa.TraverseOptions{
+		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
+			return dr.Year() == 2020 && dr.Month() == 7
 		},
+		Stations: func(id string) bool { return id == "USW00094728" },
+	}
+	var records []noaa.DailyRaw
+	seq, errf := db.Dailies(context.Background(), opts)
+	for dr := range seq {
+		records = append(records, dr)
+	}
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
+	for _, element := range []string{"TMAX", "TMIN", "TAVG", "DTR", "GDD", "HDD", "CDD", "PRCP"} {
+		ms := db.Registry.DeriveMonth(records, noaa.AllQflags)[element]
+		sum, days := ms.Sum()
+		fmt.Printf("%s 2020-07 %s: sum %.1f, mean %.1f over %d days\n", ms.Element, ms.Unit, sum, sum/float64(days), days)
+	}
+}
+
+// normals, anomalies, trends and records of the testdata stations
+func TestClimate() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	seq, errf := db.Dailies(context.Background(), noaa.TraverseOptions{
+		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool { return dr.Element() == "TMAX" },
+	})
+	collected := climate.Collect(db.Registry, seq, noaa.AllQflags)
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
+
+	var normals []climate.Normals
+	var trends []climate.Trend
+	var anomalies []climate.MonthValue
+	var records []climate.Record
+	for _, k := range slices.SortedFunc(maps.Keys(collected), func(a, b climate.Key) int { return strings.Compare(a.Id, b.Id) }) {
+		s := collected[k]
+		n := climate.ComputeNormals(s, climate.NormalsOptions{From: 2000, To: 2023})
+		normals = append(normals, n)
+		anomalies = append(anomalies, n.MonthlyAnomalies(s, 10)...)
+		records = append(records, climate.Records(s)...)
+
+		years, means := climate.AnnualMeans(s, 300)
+		for _, t := range []climate.Trend{climate.OLS(years, means, 0.95), climate.TheilSen(years, means, 0.95)} {
+			t.Id, t.Element = s.Id, s.Element
+			trends = append(trends, t)
+		}
+	}
+
+	fmt.Println(climate.NormalsFrame(normals).Filter(dataframe.F{Colname: "Month", Comparator: series.Eq, Comparando: 7}))
+	fmt.Println(climate.TrendsFrame(trends).Select([]string{"Id", "Method", "Slope", "SlopeLow", "SlopeHigh", "N"}))
+	fmt.Println(climate.AnomaliesFrame(anomalies).Arrange(dataframe.RevSort("Anomaly")).Subset([]int{0, 1, 2}))
+	fmt.Println(climate.RecordsFrame(records).Arrange(dataframe.RevSort("High")).Subset([]int{0, 1, 2}))
+}
+
+// radius, nearest, box and country queries, then a gridded TMAX normal
+func TestSpatial() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	si := db.Spatial()
+	for _, sd := range si.Within(40.71, -74.0, 600) { // new york
+		fmt.Printf("within 600 km: %s %s %.0f km\n", sd.Id, sd.Name, sd.Distance)
 	}
+	for _, sd := range si.Nearest(48.85, 2.35, 2) { // paris
+		fmt.Printf("nearest: %s %s %.0f km\n", sd.Id, sd.Name, sd.Distance)
+	}
+	fmt.Println("north america box:", len(si.BBox(15, -170, 75, -50)), "antimeridian box:", len(si.BBox(-50, 100, 0, -170)))
+	spain, _ := db.StationsIn("Spain", "")
+	ny, _ := db.StationsIn("US", "new york")
+	fmt.Println("spain:", spain[0].Name, "ny:", ny[0].Name)
+
+	seq, errf := db.Dailies(context.Background(), noaa.TraverseOptions{
+		Filter: func(dr noaa.DailyRaw, station noaa.Station) bool { return dr.Element() == "TMAX" },
+	})
+	collected := climate.Collect(db.Registry, seq, noaa.AllQflags)
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
+	var points []noaa.GridPoint
+	for k, s := range collected {
+		st := db.Stations[k.Id]
+		n := climate.ComputeNormals(s, climate.NormalsOptions{From: 2000, To: 2023})
+		points = append(points, noaa.GridPoint{Latitude: st.Latitude, Longitude: st.Longitude, Value: n.Monthly[6]})
+	}
+	grid := noaa.NewGrid(-60, -180, 80, 180, 360, 140)
+	grid.IDW(points, noaa.IDWOptions{Radius: 5000})
+	lo, hi := grid.Range()
+	fmt.Printf("july TMAX grid: %.1f..%.1f\n", lo, hi)
+	if err := grid.WritePng(os.TempDir()+"/tmax_july.png", 0, 0); err != nil {
+		log.Fatal(err)
+	}
+	if err := grid.WriteRaw(os.TempDir() + "/tmax_july"); err != nil {
+		log.Fatal(err)
+	}
+	// 1° x 0.5° cells are not square
+	global := noaa.NewGrid(-90, -180, 90, 180, 360, 360)
+	if err := global.WriteRaw(os.TempDir() + "/global"); err != nil {
+		log.Fatal(err)
+	}
+	hdr, _ := os.ReadFile(os.TempDir() + "/global.hdr")
+	if !strings.Contains(string(hdr), "xdim 1\nydim 0.5\n") {
+		log.Fatalf("global grid header:\n%s", hdr)
+	}
+	// a constant grid has no range, its cells are the middle of the ramp
+	for i := range global.Values {
+		global.Values[i] = 20
+	}
+	if err := global.WritePng(os.TempDir()+"/global.png", 0, 0); err != nil {
+		log.Fatal(err)
+	}
+	pf, err := os.Open(os.TempDir() + "/global.png")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pf.Close()
+	img, err := png.Decode(pf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(img.At(10, 10)).(color.RGBA); c != (color.RGBA{255, 255, 255, 255}) {
+		log.Fatalf("constant grid color %v", c)
+	}
+}
+
+// climatology band and warming stripes of new york from the library
+func TestPlot() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	seq, errf := db.Dailies(context.Background(), noaa.TraverseOptions{
+		Stations: func(id string) bool { return id == "USW00094728" },
+		Filter:   func(dr noaa.DailyRaw, station noaa.Station) bool { return dr.Element() == "TMAX" },
+	})
+	collected := climate.Collect(db.Registry, seq, noaa.AllQflags)
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
+	nyc := collected[climate.Key{Id: "USW00094728", Element: "TMAX"}]
+	normals := climate.ComputeNormals(nyc, climate.NormalsOptions{From: 2000, To: 2023})
+	year := nyc.Year(2023)
+
+	dir, err := os.MkdirTemp("", "plot")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := plot.Save(plot.Climatology("new york TMAX", normals, "°C", &year), dir+"/climatology.svg"); err != nil {
+		log.Fatal(err)
+	}
+	years, values := climate.AnnualMeans(nyc, 300)
+	if err := plot.Save(plot.NewStripes(years, climate.Anomalies(years, values, 2000, 2023)), dir+"/stripes.png"); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("plots in", dir)
+}
+
+// the http api over testdata, from the tarball and from a column cache
+func TestServer() {
+	db, err := noaa.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler, err := server.New(db, server.Options{PageSize: 2})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	get := func(url string, header http.Header, status int) (http.Header, string) {
+		req, _ := http.NewRequest("GET", ts.URL+url, nil)
+		maps.Copy(req.Header, header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != status {
+			log.Fatalf("%s: status %d, want %d: %s", url, resp.StatusCode, status, body)
+		}
+		return resp.Header, string(body)
+	}
+
+	h, body := get("/stations", nil, http.StatusOK)
+	fmt.Printf("stations: total %s, link %s\n", h.Get("X-Total-Count"), h.Get("Link"))
+	_, body = get("/stations?near=40.7,-74&radius=600&format=csv", nil, http.StatusOK)
+	fmt.Print(body)
+	_, body = get("/stations/SP000003195", nil, http.StatusOK)
+	fmt.Println("station:", len(body), "bytes")
+	get("/stations/XX000000000", nil, http.StatusNotFound)
+	get("/series?station=USW00094728", nil, http.StatusBadRequest)
+	get("/stations?limit=0", nil, http.StatusBadRequest)
+	h, body = get("/stations?offset=9223372036854775807", nil, http.StatusOK)
+	if !strings.Contains(body, `"items": []`) || strings.Contains(h.Get("Link"), "next") {
+		log.Fatalf("page past the end: %s, link %s", body, h.Get("Link"))
+	}
+
+	h, body = get("/series?station=USW00094728&element=TMAX&from=2023&to=2023&offset=10", http.Header{"Accept": {"text/csv"}}, http.StatusOK)
+	fmt.Printf("series: total %s, etag %s\n%s", h.Get("X-Total-Count"), h.Get("ETag"), body)
+	get("/series?station=USW00094728&element=TMAX&from=2023&to=2023&offset=10", http.Header{"Accept": {"text/csv"}, "If-None-Match": {h.Get("ETag")}}, http.StatusNotModified)
 
-	f, _ := os.Create("chart.png")
-	defer f.Close()
-	graph.Render(chart.PNG, f)
+	const aggregate = "/aggregate?element=PRCP&group=country&period=year&from=2020&limit=100&format=csv"
+	_, fromTarball := get(aggregate, nil, http.StatusOK)
+	fmt.Print(fromTarball)
+
+	dir, err := os.MkdirTemp("", "cache")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := noaa.Ingest(context.Background(), db, dir, noaa.TraverseOptions{}); err != nil {
+		log.Fatal(err)
+	}
+	cache, err := noaa.OpenCache(db, dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cache.Close()
+	cached, err := server.New(db, server.Options{Cache: cache})
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	cached.ServeHTTP(rec, httptest.NewRequest("GET", aggregate, nil))
+	fmt.Println("cache aggregate matches:", rec.Body.String() == fromTarball)
+
+	// ingest again, us stations only, while the server has the old files open
+	if err := noaa.Ingest(context.Background(), db, dir, noaa.TraverseOptions{Stations: func(id string) bool { return strings.HasPrefix(id, "US") }}); err != nil {
+		log.Fatal(err)
+	}
+	fresh, err := noaa.OpenCache(db, dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fresh.Close()
+	want := httptest.NewRecorder()
+	fromFresh, _ := server.New(db, server.Options{Cache: fresh})
+	fromFresh.ServeHTTP(want, httptest.NewRequest("GET", aggregate, nil))
+	again := httptest.NewRecorder()
+	cached.ServeHTTP(again, httptest.NewRequest("GET", aggregate, nil))
+	if again.Code != http.StatusOK || again.Body.String() != want.Body.String() || again.Body.String() == fromTarball ||
+		again.Header().Get("ETag") == rec.Header().Get("ETag") {
+		log.Fatalf("aggregate after ingesting again, etag %s:\n%s\nwant:\n%s", again.Header().Get("ETag"), again.Body.String(), want.Body.String())
+	}
+	fmt.Print("cache aggregate after ingesting again:\n", again.Body.String())
+}
+
+// write a superghcnd diff tarball of insert, update and delete csv rows
+func writeDiff(dir, from, to string, csvs map[string]string) string {
+	name := filepath.Join(dir, "superghcnd_diff_"+from+"_to_"+to+".tar.gz")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range []string{"insert.csv", "update.csv", "delete.csv"} {
+		tw.WriteHeader(&tar.Header{Name: "diff/" + file, Mode: 0644, Size: int64(len(csvs[file])), Typeflag: tar.TypeReg})
+		tw.Write([]byte(csvs[file]))
+	}
+	tw.Close()
+	gz.Close()
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	return name
+}
+
+// superghcnd diffs applied to the extracted testdata tarball, twice
+func TestUpdate() {
+	dir, err := os.MkdirTemp("", "update")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// aux files and the .dly files of the tarball
+	for _, file := range noaa.AuxFiles {
+		data, _ := os.ReadFile("testdata/" + noaa.AuxFilePrefix + file + ".txt")
+		os.WriteFile(filepath.Join(dir, noaa.AuxFilePrefix+file+".txt"), data, 0644)
+	}
+	tgz, err := os.Open("testdata/" + noaa.DailyTarBall)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gz, _ := gzip.NewReader(tgz)
+	tr := tar.NewReader(gz)
+	os.Mkdir(filepath.Join(dir, "ghcnd_all"), 0755)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		if hdr.Typeflag == tar.TypeReg {
+			data, _ := io.ReadAll(tr)
+			os.WriteFile(filepath.Join(dir, hdr.Name), data, 0644)
+		}
+	}
+	tgz.Close()
+
+	diffs := []string{
+		writeDiff(dir, "20240101", "20240102", map[string]string{
+			"insert.csv": "USW00094728,20240101,TMAX,55,,,S,\nZZ000000001,20240101,PRCP,12,,,S,\n",
+			"update.csv": "SP000003195,20230615,TMAX,999,,X,S,\n",
+			"delete.csv": "ASN00066062,20230101,TMAX,0,,,,\n",
+		}),
+		writeDiff(dir, "20240102", "20240103", map[string]string{
+			"insert.csv": "USW00094728,20240102,TMAX,61,,,S,\n",
+			"delete.csv": "ZZ000000001,20240101,PRCP,0,,,,\n",
+		}),
+	}
+	stations := filepath.Join(dir, "ghcnd_all")
+
+	// etag of a served station before the update
+	served, err := noaa.Config{Path: dir, DailyDir: "ghcnd_all"}.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler, err := server.New(served, server.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	const series = "/series?station=USW00094728&element=TMAX&from=2024"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", series, nil))
+	before := rec.Header().Get("ETag")
+
+	// a dry run of chained diffs counts what applying them does
+	dry, err := noaa.ApplyDiffs(stations, diffs, noaa.UpdateOptions{DryRun: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for range 2 {
+		res, err := noaa.ApplyDiffs(stations, diffs, noaa.UpdateOptions{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(res.Applied) == 2 && (dry.Inserted != res.Inserted || dry.Updated != res.Updated || dry.Deleted != res.Deleted || dry.Missing != res.Missing) {
+			log.Fatalf("dry run %+v, applied %+v", dry, res)
+		}
+		fmt.Printf("applied %d, skipped %d: %d stations, %d inserted, %d updated, %d deleted, current to %s\n",
+			len(res.Applied), len(res.Skipped), res.Stations, res.Inserted, res.Updated, res.Deleted, res.Current)
+	}
+	req := httptest.NewRequest("GET", series, nil)
+	req.Header.Set("If-None-Match", before)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == before {
+		log.Fatalf("stale etag after update: status %d, etag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	fmt.Printf("etag %s -> %s: %s", before, rec.Header().Get("ETag"), rec.Body.String()[:80])
+	fmt.Println()
+
+	gap := writeDiff(dir, "20240105", "20240106", map[string]string{"insert.csv": ""})
+	if _, err := noaa.ApplyDiffs(stations, []string{gap}, noaa.UpdateOptions{}); !errors.Is(err, noaa.ErrDiffGap) {
+		log.Fatalf("gap not detected: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stations, "ZZ000000001.dly")); !errors.Is(err, os.ErrNotExist) {
+		log.Fatal("emptied station file not removed")
+	}
+
+	db, err := noaa.Config{Path: dir, DailyDir: "ghcnd_all"}.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	seq, errf := db.Dailies(context.Background(), noaa.TraverseOptions{Filter: func(dr noaa.DailyRaw, station noaa.Station) bool {
+		ym := dr.Year()*100 + dr.Month()
+		return dr.Element() == "TMAX" && (ym == 202401 || ym == 202306 || ym == 202301)
+	}})
+	for dr := range seq {
+		day := map[int]int{202401: 0, 202306: 14, 202301: 0}[dr.Year()*100+dr.Month()]
+		value, ok := dr.Day(day)
+		fmt.Printf("%s %d-%02d-%02d TMAX %d %v qflag %q\n", dr.Id(), dr.Year(), dr.Month(), day+1, value, ok, dr.Qflag(day))
+	}
+	if err := errf(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
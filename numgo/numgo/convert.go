@@ -0,0 +1,142 @@
+// dtype conversions
+
+package numgo
+
+import "fmt"
+
+// numpy astype semantics: truncation to ints, != 0 to bool, imaginary part 0 to complex
+func castReal[T Elem, S Real](src []S) []T {
+	dst := make([]T, len(src))
+	switch d := any(dst).(type) {
+	case []bool:
+		for i, v := range src {
+			d[i] = v != 0
+		}
+	case []int8:
+		for i, v := range src {
+			d[i] = int8(v)
+		}
+	case []int16:
+		for i, v := range src {
+			d[i] = int16(v)
+		}
+	case []int32:
+		for i, v := range src {
+			d[i] = int32(v)
+		}
+	case []int64:
+		for i, v := range src {
+			d[i] = int64(v)
+		}
+	case []uint8:
+		for i, v := range src {
+			d[i] = uint8(v)
+		}
+	case []uint16:
+		for i, v := range src {
+			d[i] = uint16(v)
+		}
+	case []uint32:
+		for i, v := range src {
+			d[i] = uint32(v)
+		}
+	case []uint64:
+		for i, v := range src {
+			d[i] = uint64(v)
+		}
+	case []Float16:
+		for i, v := range src {
+			d[i] = NewFloat16(float32(v))
+		}
+	case []float32:
+		for i, v := range src {
+			d[i] = float32(v)
+		}
+	case []float64:
+		for i, v := range src {
+			d[i] = float64(v)
+		}
+	case []complex64:
+		for i, v := range src {
+			d[i] = complex(float32(v), 0)
+		}
+	case []complex128:
+		for i, v := range src {
+			d[i] = complex(float64(v), 0)
+		}
+	}
+	return dst
+}
+
+// complex only casts to complex, numpy would drop the imaginary part
+func castComplex[T Elem, S complex64 | complex128](src []S) ([]T, error) {
+	dst := make([]T, len(src))
+	switch d := any(dst).(type) {
+	case []complex64:
+		for i, v := range src {
+			d[i] = complex64(v)
+		}
+	case []complex128:
+		for i, v := range src {
+			d[i] = complex128(v)
+		}
+	default:
+		return nil, fmt.Errorf("cannot cast complex to %s", DTypeOf[T]().Name())
+	}
+	return dst, nil
+}
+
+// a converted to T, a itself if it already is an *NDArray[T]
+func AsType[T Elem](a Array) (*NDArray[T], error) {
+	if t, ok := a.(*NDArray[T]); ok {
+		return t, nil
+	}
+	var values []T
+	switch src := a.elems().(type) {
+	case []bool:
+		u := make([]uint8, len(src))
+		for i, v := range src {
+			if v {
+				u[i] = 1
+			}
+		}
+		values = castReal[T](u)
+	case []Float16:
+		f := make([]float32, len(src))
+		for i, v := range src {
+			f[i] = v.Float32()
+		}
+		values = castReal[T](f)
+	case []int8:
+		values = castReal[T](src)
+	case []int16:
+		values = castReal[T](src)
+	case []int32:
+		values = castReal[T](src)
+	case []int64:
+		values = castReal[T](src)
+	case []uint8:
+		values = castReal[T](src)
+	case []uint16:
+		values = castReal[T](src)
+	case []uint32:
+		values = castReal[T](src)
+	case []uint64:
+		values = castReal[T](src)
+	case []float32:
+		values = castReal[T](src)
+	case []float64:
+		values = castReal[T](src)
+	case []complex64:
+		var err error
+		if values, err = castComplex[T](src); err != nil {
+			return nil, err
+		}
+	case []complex128:
+		var err error
+		if values, err = castComplex[T](src); err != nil {
+			return nil, err
+		}
+	}
+	return FromSlice(values, a.Shape()...)
+}
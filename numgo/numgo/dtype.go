@@ -0,0 +1,132 @@
+// element types and their numpy dtype descriptors
+
+package numgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// go types of the numpy dtypes
+type Elem interface {
+	bool | int8 | int16 | int32 | int64 | uint8 | uint16 | uint32 | uint64 |
+		Float16 | float32 | float64 | complex64 | complex128
+}
+
+// ordered element types
+type Real interface {
+	int8 | int16 | int32 | int64 | uint8 | uint16 | uint32 | uint64 | float32 | float64
+}
+
+// element types with arithmetic, float16 is storage only
+type Number interface {
+	Real | complex64 | complex128
+}
+
+// numpy dtype: kind b (bool), i, u, f or c, the item size in bytes and the byte order
+type DType struct {
+	Kind  byte
+	Size  int
+	Order binary.ByteOrder
+}
+
+// dtype of T, little endian
+func DTypeOf[T Elem]() DType {
+	var v T
+	le := binary.LittleEndian
+	switch any(v).(type) {
+	case bool:
+		return DType{'b', 1, le}
+	case int8:
+		return DType{'i', 1, le}
+	case int16:
+		return DType{'i', 2, le}
+	case int32:
+		return DType{'i', 4, le}
+	case int64:
+		return DType{'i', 8, le}
+	case uint8:
+		return DType{'u', 1, le}
+	case uint16:
+		return DType{'u', 2, le}
+	case uint32:
+		return DType{'u', 4, le}
+	case uint64:
+		return DType{'u', 8, le}
+	case Float16:
+		return DType{'f', 2, le}
+	case float32:
+		return DType{'f', 4, le}
+	case float64:
+		return DType{'f', 8, le}
+	case complex64:
+		return DType{'c', 8, le}
+	default: // complex128
+		return DType{'c', 16, le}
+	}
+}
+
+// parse a descr like '<f8', '>i4' or '|b1'
+func ParseDType(descr string) (DType, error) {
+	if len(descr) < 3 {
+		return DType{}, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	dt := DType{Kind: descr[1]}
+	switch descr[0] {
+	case '<', '|', '=': // '=' native, little endian on the supported platforms
+		dt.Order = binary.LittleEndian
+	case '>':
+		dt.Order = binary.BigEndian
+	default:
+		return DType{}, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	size, err := strconv.Atoi(descr[2:])
+	if err != nil {
+		return DType{}, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	dt.Size = size
+
+	sizes := map[byte][]int{'b': {1}, 'i': {1, 2, 4, 8}, 'u': {1, 2, 4, 8}, 'f': {2, 4, 8}, 'c': {8, 16}}
+	ok := false
+	for _, s := range sizes[dt.Kind] {
+		ok = ok || s == size
+	}
+	if !ok {
+		return DType{}, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	return dt, nil
+}
+
+// numpy descr, '|' for single bytes
+func (dt DType) String() string {
+	order := byte('<')
+	switch {
+	case dt.Size == 1:
+		order = '|'
+	case dt.Order == binary.BigEndian:
+		order = '>'
+	}
+	return fmt.Sprintf("%c%c%d", order, dt.Kind, dt.Size)
+}
+
+// numpy name: bool, int32, float64, ...
+func (dt DType) Name() string {
+	switch dt.Kind {
+	case 'b':
+		return "bool"
+	case 'i':
+		return fmt.Sprintf("int%d", dt.Size*8)
+	case 'u':
+		return fmt.Sprintf("uint%d", dt.Size*8)
+	case 'f':
+		return fmt.Sprintf("float%d", dt.Size*8)
+	default:
+		return fmt.Sprintf("complex%d", dt.Size*8)
+	}
+}
+
+// same kind and size, any byte order
+func (dt DType) Same(o DType) bool {
+	return dt.Kind == o.Kind && dt.Size == o.Size
+}
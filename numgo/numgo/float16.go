@@ -0,0 +1,70 @@
+// ieee 754 half precision
+
+package numgo
+
+import (
+	"math"
+	"strconv"
+)
+
+// bits of a float16
+type Float16 uint16
+
+// float32 to the nearest float16, ties to even
+func NewFloat16(f float32) Float16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case b&0x7fffffff > 0x7f800000: // nan, keep it quiet
+		return Float16(sign | 0x7e00 | uint16(mant>>13))
+	case exp >= 0x1f: // overflow and inf
+		return Float16(sign | 0x7c00)
+	case exp <= 0: // subnormal or zero
+		if exp < -10 {
+			return Float16(sign)
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		half := uint32(1) << (shift - 1)
+		m := mant >> shift
+		if rest := mant & (1<<shift - 1); rest > half || rest == half && m&1 == 1 {
+			m++
+		}
+		return Float16(sign | uint16(m))
+	}
+	m := uint16(mant >> 13)
+	h := sign | uint16(exp)<<10 | m
+	if rest := mant & 0x1fff; rest > 0x1000 || rest == 0x1000 && m&1 == 1 {
+		h++ // may carry into the exponent, up to inf
+	}
+	return Float16(h)
+}
+
+func (h Float16) Float32() float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h >> 10 & 0x1f)
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// normalize the subnormal
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		return math.Float32frombits(sign | e<<23 | (mant&0x3ff)<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+func (h Float16) String() string {
+	return strconv.FormatFloat(float64(h.Float32()), 'g', -1, 32)
+}
@@ -0,0 +1,223 @@
+// strided n-dimensional arrays
+
+package numgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"slices"
+)
+
+// an array of any element type, what Read returns and Write takes
+type Array interface {
+	Shape() []int
+	DType() DType
+	Size() int
+	elems() any // []T in C order
+	writeNpy(w *npyWriter) error
+}
+
+// n-d view of data, element i,j,.. at offset + i*strides[0] + j*strides[1] + ..
+type NDArray[T Elem] struct {
+	data    []T
+	shape   []int
+	strides []int // in elements
+	offset  int
+	order   binary.ByteOrder // of the file it was read from, little endian if nil
+}
+
+// zeroed array
+func New[T Elem](shape ...int) *NDArray[T] {
+	a, _ := FromSlice(make([]T, product(shape)), shape...)
+	return a
+}
+
+// C order array over data without copying
+func FromSlice[T Elem](data []T, shape ...int) (*NDArray[T], error) {
+	if slices.ContainsFunc(shape, func(n int) bool { return n < 0 }) {
+		return nil, fmt.Errorf("negative dimension in shape %v", shape)
+	}
+	if product(shape) != len(data) {
+		return nil, fmt.Errorf("cannot shape %d elements as %v", len(data), shape)
+	}
+	shape = slices.Clone(shape)
+	return &NDArray[T]{data: data, shape: shape, strides: cStrides(shape)}, nil
+}
+
+// 0-d array of v, broadcasts against anything
+func Scalar[T Elem](v T) *NDArray[T] {
+	return &NDArray[T]{data: []T{v}, shape: []int{}, strides: []int{}}
+}
+
+// 0, 1, .. n-1
+func Arange[T Real](n int) *NDArray[T] {
+	data := make([]T, n)
+	for i := range data {
+		data[i] = T(i)
+	}
+	a, _ := FromSlice(data, n)
+	return a
+}
+
+func product(shape []int) int {
+	n := 1
+	for _, d := range shape {
+		n *= d
+	}
+	return n
+}
+
+// row major strides
+func cStrides(shape []int) []int {
+	strides := make([]int, len(shape))
+	s := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = s
+		s *= max(shape[i], 1)
+	}
+	return strides
+}
+
+// column major strides
+func fStrides(shape []int) []int {
+	strides := make([]int, len(shape))
+	s := 1
+	for i, d := range shape {
+		strides[i] = s
+		s *= max(d, 1)
+	}
+	return strides
+}
+
+func (a *NDArray[T]) Shape() []int   { return slices.Clone(a.shape) }
+func (a *NDArray[T]) Strides() []int { return slices.Clone(a.strides) }
+func (a *NDArray[T]) Ndim() int      { return len(a.shape) }
+func (a *NDArray[T]) Size() int      { return product(a.shape) }
+
+func (a *NDArray[T]) DType() DType {
+	dt := DTypeOf[T]()
+	if a.order != nil {
+		dt.Order = a.order
+	}
+	return dt
+}
+
+// byte order the array is written in, little endian by default
+func (a *NDArray[T]) SetByteOrder(order binary.ByteOrder) {
+	a.order = order
+}
+
+func (a *NDArray[T]) index(idx []int) int {
+	if len(idx) != len(a.shape) {
+		panic(fmt.Sprintf("numgo: %d indices for %d dimensions", len(idx), len(a.shape)))
+	}
+	off := a.offset
+	for i, j := range idx {
+		if j < 0 || j >= a.shape[i] {
+			panic(fmt.Sprintf("numgo: index %d out of range for axis %d of size %d", j, i, a.shape[i]))
+		}
+		off += j * a.strides[i]
+	}
+	return off
+}
+
+func (a *NDArray[T]) At(idx ...int) T {
+	return a.data[a.index(idx)]
+}
+
+func (a *NDArray[T]) Set(v T, idx ...int) {
+	a.data[a.index(idx)] = v
+}
+
+// data offsets of the elements in C order
+func (a *NDArray[T]) offsets() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		walk(a.shape, []int{a.offset}, [][]int{a.strides}, func(offs []int) bool { return yield(offs[0]) })
+	}
+}
+
+// C order walk over shape of several strided layouts at once, offs their offsets
+func walk(shape []int, starts []int, strides [][]int, yield func(offs []int) bool) {
+	if product(shape) == 0 {
+		return
+	}
+	idx := make([]int, len(shape))
+	offs := slices.Clone(starts)
+	for {
+		if !yield(offs) {
+			return
+		}
+		i := len(idx) - 1
+		for ; i >= 0; i-- {
+			idx[i]++
+			for k := range offs {
+				offs[k] += strides[k][i]
+			}
+			if idx[i] < shape[i] {
+				break
+			}
+			for k := range offs {
+				offs[k] -= idx[i] * strides[k][i]
+			}
+			idx[i] = 0
+		}
+		if i < 0 {
+			return
+		}
+	}
+}
+
+// the element of a size 1 array
+func (a *NDArray[T]) Item() T {
+	if a.Size() != 1 {
+		panic(fmt.Sprintf("numgo: Item of an array of size %d", a.Size()))
+	}
+	return a.data[a.offset]
+}
+
+// elements in C order
+func (a *NDArray[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for off := range a.offsets() {
+			if !yield(a.data[off]) {
+				return
+			}
+		}
+	}
+}
+
+// copy of the elements in C order
+func (a *NDArray[T]) Values() []T {
+	values := make([]T, 0, a.Size())
+	for v := range a.All() {
+		values = append(values, v)
+	}
+	return values
+}
+
+func (a *NDArray[T]) elems() any { return a.Values() }
+
+// laid out in C order from offset
+func (a *NDArray[T]) IsContiguous() bool {
+	return contiguous(a.shape, a.strides, cStrides(a.shape))
+}
+
+// laid out in Fortran order from offset
+func (a *NDArray[T]) IsFortran() bool {
+	return contiguous(a.shape, a.strides, fStrides(a.shape))
+}
+
+// strides of axes of size 1 don't matter
+func contiguous(shape, strides, want []int) bool {
+	for i, d := range shape {
+		if d > 1 && strides[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *NDArray[T]) String() string {
+	return fmt.Sprintf("NDArray[%s]%v", a.DType().Name(), a.shape)
+}
@@ -0,0 +1,515 @@
+// npy file format
+//
+//	\x93NUMPY major minor header_len header data
+//
+// header_len is a little endian uint16 in version 1, uint32 in 2 and 3,
+// the header a python dict literal {'descr': '<f8', 'fortran_order': False, 'shape': (5, 4), }
+// padded with spaces and \n to align the data, latin1 before version 3 and utf8 in it
+
+package numgo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const npyMagic = "\x93NUMPY"
+
+// data alignment of the writers, as numpy
+const npyAlign = 64
+
+type Header struct {
+	Major, Minor byte
+	DType        DType
+	Fortran      bool
+	Shape        []int
+}
+
+func ReadHeader(r io.Reader) (Header, error) {
+	var h Header
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return h, fmt.Errorf("failed to read npy magic: %w", err)
+	}
+	if string(prefix[:len(npyMagic)]) != npyMagic {
+		return h, fmt.Errorf("not an npy file")
+	}
+	h.Major, h.Minor = prefix[len(npyMagic)], prefix[len(npyMagic)+1]
+
+	var hlen int
+	switch h.Major {
+	case 1:
+		var n uint16
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return h, fmt.Errorf("failed to read npy header length: %w", err)
+		}
+		hlen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return h, fmt.Errorf("failed to read npy header length: %w", err)
+		}
+		hlen = int(n)
+	default:
+		return h, fmt.Errorf("unsupported npy version %d.%d", h.Major, h.Minor)
+	}
+	raw := make([]byte, hlen)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return h, fmt.Errorf("failed to read npy header: %w", err)
+	}
+	text := latin1(raw)
+	if h.Major == 3 {
+		if !utf8.Valid(raw) {
+			return h, fmt.Errorf("npy header is not utf8")
+		}
+		text = string(raw)
+	}
+
+	dict, err := parseDict(text)
+	if err != nil {
+		return h, fmt.Errorf("failed to parse npy header %q: %w", text, err)
+	}
+	descr, ok := dict["descr"].(string)
+	if !ok {
+		return h, fmt.Errorf("npy header without a descr string, structured dtypes are not supported")
+	}
+	if h.DType, err = ParseDType(descr); err != nil {
+		return h, err
+	}
+	if h.Fortran, ok = dict["fortran_order"].(bool); !ok {
+		return h, fmt.Errorf("npy header without fortran_order")
+	}
+	if h.Shape, ok = dict["shape"].([]int); !ok {
+		return h, fmt.Errorf("npy header without shape")
+	}
+	// the byte size, with empty axes as 1 for the strides, must fit an int
+	nbytes := h.DType.Size
+	for _, d := range h.Shape {
+		if d < 0 {
+			return h, fmt.Errorf("negative dimension in npy shape %v", h.Shape)
+		}
+		if d > 1 && nbytes > math.MaxInt/d {
+			return h, fmt.Errorf("npy shape %v of %s is too large", h.Shape, h.DType)
+		}
+		nbytes *= max(d, 1)
+	}
+	return h, nil
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// {'key': value, ...} with string, True/False and int tuple values
+func parseDict(s string) (map[string]any, error) {
+	p := &dictParser{s: s}
+	p.skip()
+	if !p.eat('{') {
+		return nil, fmt.Errorf("expected {")
+	}
+	dict := map[string]any{}
+	for {
+		p.skip()
+		if p.eat('}') {
+			break
+		}
+		key, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		p.skip()
+		if !p.eat(':') {
+			return nil, fmt.Errorf("expected : after %q", key)
+		}
+		p.skip()
+		if dict[key], err = p.value(); err != nil {
+			return nil, err
+		}
+		p.skip()
+		if !p.eat(',') {
+			p.skip()
+			if !p.eat('}') {
+				return nil, fmt.Errorf("expected , or } at %d", p.i)
+			}
+			break
+		}
+	}
+	if p.skip(); p.i != len(s) {
+		return nil, fmt.Errorf("trailing %q", s[p.i:])
+	}
+	return dict, nil
+}
+
+type dictParser struct {
+	s string
+	i int
+}
+
+func (p *dictParser) skip() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *dictParser) eat(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// quoted string, no escapes in dtype descrs
+func (p *dictParser) str() (string, error) {
+	if p.i >= len(p.s) || p.s[p.i] != '\'' && p.s[p.i] != '"' {
+		return "", fmt.Errorf("expected a string at %d", p.i)
+	}
+	q := p.s[p.i]
+	end := strings.IndexByte(p.s[p.i+1:], q)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string at %d", p.i)
+	}
+	str := p.s[p.i+1 : p.i+1+end]
+	p.i += end + 2
+	return str, nil
+}
+
+func (p *dictParser) value() (any, error) {
+	switch {
+	case p.i >= len(p.s):
+		return nil, fmt.Errorf("unexpected end")
+	case p.s[p.i] == '\'' || p.s[p.i] == '"':
+		return p.str()
+	case strings.HasPrefix(p.s[p.i:], "True"):
+		p.i += 4
+		return true, nil
+	case strings.HasPrefix(p.s[p.i:], "False"):
+		p.i += 5
+		return false, nil
+	case p.s[p.i] == '(':
+		p.i++
+		tuple := []int{}
+		for {
+			p.skip()
+			if p.eat(')') {
+				return tuple, nil
+			}
+			start := p.i
+			for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+				p.i++
+			}
+			n, err := strconv.Atoi(p.s[start:p.i])
+			if err != nil {
+				return nil, fmt.Errorf("expected an int at %d", start)
+			}
+			p.eat('L') // python 2 long
+			tuple = append(tuple, n)
+			p.skip()
+			if !p.eat(',') {
+				p.skip()
+				if !p.eat(')') {
+					return nil, fmt.Errorf("expected , or ) at %d", p.i)
+				}
+				return tuple, nil
+			}
+		}
+	case p.s[p.i] == '[':
+		return nil, fmt.Errorf("structured dtypes are not supported")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", p.s[p.i], p.i)
+}
+
+// the header as numpy writes it: sorted keys, room to grow the first
+// (fortran: last) axis in place, padded so that the data is aligned
+func (h Header) encode() []byte {
+	var dict strings.Builder
+	fortran := "False"
+	if h.Fortran {
+		fortran = "True"
+	}
+	fmt.Fprintf(&dict, "{'descr': '%s', 'fortran_order': %s, 'shape': %s, }", h.DType, fortran, pyTuple(h.Shape))
+	if len(h.Shape) > 0 {
+		grow := h.Shape[0]
+		if h.Fortran {
+			grow = h.Shape[len(h.Shape)-1]
+		}
+		dict.WriteString(strings.Repeat(" ", 21-len(strconv.Itoa(grow))))
+	}
+	text := dict.String()
+
+	major := byte(1)
+	pad := npyAlign - (len(npyMagic)+2+2+len(text)+1)%npyAlign
+	if len(text)+1+pad > math.MaxUint16 {
+		major = 2
+		pad = npyAlign - (len(npyMagic)+2+4+len(text)+1)%npyAlign
+	}
+	hlen := len(text) + 1 + pad
+
+	var b bytes.Buffer
+	b.WriteString(npyMagic)
+	b.WriteByte(major)
+	b.WriteByte(0)
+	if major == 1 {
+		binary.Write(&b, binary.LittleEndian, uint16(hlen))
+	} else {
+		binary.Write(&b, binary.LittleEndian, uint32(hlen))
+	}
+	b.WriteString(text)
+	b.WriteString(strings.Repeat(" ", pad))
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// python repr of a tuple: (), (5,), (5, 4)
+func pyTuple(shape []int) string {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = strconv.Itoa(d)
+	}
+	switch len(dims) {
+	case 0:
+		return "()"
+	case 1:
+		return "(" + dims[0] + ",)"
+	}
+	return "(" + strings.Join(dims, ", ") + ")"
+}
+
+// element decoder and encoder of T in order
+func codec[T Elem](order binary.ByteOrder) (func([]byte) T, func([]byte, T)) {
+	var dec, enc any
+	switch any(*new(T)).(type) {
+	case bool:
+		dec = func(b []byte) bool { return b[0] != 0 }
+		enc = func(b []byte, v bool) {
+			b[0] = 0
+			if v {
+				b[0] = 1
+			}
+		}
+	case int8:
+		dec = func(b []byte) int8 { return int8(b[0]) }
+		enc = func(b []byte, v int8) { b[0] = byte(v) }
+	case uint8:
+		dec = func(b []byte) uint8 { return b[0] }
+		enc = func(b []byte, v uint8) { b[0] = v }
+	case int16:
+		dec = func(b []byte) int16 { return int16(order.Uint16(b)) }
+		enc = func(b []byte, v int16) { order.PutUint16(b, uint16(v)) }
+	case uint16:
+		dec = func(b []byte) uint16 { return order.Uint16(b) }
+		enc = func(b []byte, v uint16) { order.PutUint16(b, v) }
+	case int32:
+		dec = func(b []byte) int32 { return int32(order.Uint32(b)) }
+		enc = func(b []byte, v int32) { order.PutUint32(b, uint32(v)) }
+	case uint32:
+		dec = func(b []byte) uint32 { return order.Uint32(b) }
+		enc = func(b []byte, v uint32) { order.PutUint32(b, v) }
+	case int64:
+		dec = func(b []byte) int64 { return int64(order.Uint64(b)) }
+		enc = func(b []byte, v int64) { order.PutUint64(b, uint64(v)) }
+	case uint64:
+		dec = func(b []byte) uint64 { return order.Uint64(b) }
+		enc = func(b []byte, v uint64) { order.PutUint64(b, v) }
+	case Float16:
+		dec = func(b []byte) Float16 { return Float16(order.Uint16(b)) }
+		enc = func(b []byte, v Float16) { order.PutUint16(b, uint16(v)) }
+	case float32:
+		dec = func(b []byte) float32 { return math.Float32frombits(order.Uint32(b)) }
+		enc = func(b []byte, v float32) { order.PutUint32(b, math.Float32bits(v)) }
+	case float64:
+		dec = func(b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }
+		enc = func(b []byte, v float64) { order.PutUint64(b, math.Float64bits(v)) }
+	case complex64:
+		dec = func(b []byte) complex64 {
+			return complex(math.Float32frombits(order.Uint32(b)), math.Float32frombits(order.Uint32(b[4:])))
+		}
+		enc = func(b []byte, v complex64) {
+			order.PutUint32(b, math.Float32bits(real(v)))
+			order.PutUint32(b[4:], math.Float32bits(imag(v)))
+		}
+	case complex128:
+		dec = func(b []byte) complex128 {
+			return complex(math.Float64frombits(order.Uint64(b)), math.Float64frombits(order.Uint64(b[8:])))
+		}
+		enc = func(b []byte, v complex128) {
+			order.PutUint64(b, math.Float64bits(real(v)))
+			order.PutUint64(b[8:], math.Float64bits(imag(v)))
+		}
+	}
+	return dec.(func([]byte) T), enc.(func([]byte, T))
+}
+
+// array of the header's shape and layout from the data after it
+func readData[T Elem](r io.Reader, h Header) (*NDArray[T], error) {
+	n := product(h.Shape)
+	size := h.DType.Size
+	dec, _ := codec[T](h.DType.Order)
+	buf := make([]byte, 64<<10)
+	// grown as the data arrives, a header can claim more than the file has
+	data := make([]T, 0, min(n, len(buf)/size))
+	for len(data) < n {
+		chunk := min(n-len(data), len(buf)/size)
+		b := buf[:chunk*size]
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("failed to read npy data of shape %v: %w", h.Shape, err)
+		}
+		for j := range chunk {
+			data = append(data, dec(b[j*size:]))
+		}
+	}
+
+	a := &NDArray[T]{data: data, shape: h.Shape, strides: cStrides(h.Shape)}
+	if h.Fortran {
+		a.strides = fStrides(h.Shape)
+	}
+	if size > 1 && h.DType.Order == binary.BigEndian {
+		a.order = binary.BigEndian
+	}
+	return a, nil
+}
+
+// an npy array of its own dtype
+func Read(r io.Reader) (Array, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	switch h.DType.Name() {
+	case "bool":
+		return readData[bool](r, h)
+	case "int8":
+		return readData[int8](r, h)
+	case "int16":
+		return readData[int16](r, h)
+	case "int32":
+		return readData[int32](r, h)
+	case "int64":
+		return readData[int64](r, h)
+	case "uint8":
+		return readData[uint8](r, h)
+	case "uint16":
+		return readData[uint16](r, h)
+	case "uint32":
+		return readData[uint32](r, h)
+	case "uint64":
+		return readData[uint64](r, h)
+	case "float16":
+		return readData[Float16](r, h)
+	case "float32":
+		return readData[float32](r, h)
+	case "float64":
+		return readData[float64](r, h)
+	case "complex64":
+		return readData[complex64](r, h)
+	default:
+		return readData[complex128](r, h)
+	}
+}
+
+// an npy array converted to T
+func ReadAs[T Elem](r io.Reader) (*NDArray[T], error) {
+	a, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	return AsType[T](a)
+}
+
+func Load(filename string) (Array, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer f.Close()
+	a, err := Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return a, nil
+}
+
+func LoadAs[T Elem](filename string) (*NDArray[T], error) {
+	a, err := Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	return AsType[T](a)
+}
+
+type npyWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// header and data, views are written in C order, fortran ordered arrays as such
+func (a *NDArray[T]) writeNpy(w *npyWriter) error {
+	dt := a.DType()
+	h := Header{DType: dt, Shape: a.shape}
+	offsets := a.offsets()
+	if !a.IsContiguous() && a.IsFortran() {
+		h.Fortran = true
+		offsets = func(yield func(int) bool) {
+			for i := range a.Size() {
+				if !yield(a.offset + i) {
+					return
+				}
+			}
+		}
+	}
+	if _, err := w.w.Write(h.encode()); err != nil {
+		return fmt.Errorf("failed to write npy header: %w", err)
+	}
+
+	_, enc := codec[T](dt.Order)
+	b := w.buf[:0]
+	for off := range offsets {
+		n := len(b)
+		b = append(b, make([]byte, dt.Size)...)
+		enc(b[n:], a.data[off])
+		if len(b) >= 64<<10 {
+			if _, err := w.w.Write(b); err != nil {
+				return fmt.Errorf("failed to write npy data: %w", err)
+			}
+			b = b[:0]
+		}
+	}
+	if _, err := w.w.Write(b); err != nil {
+		return fmt.Errorf("failed to write npy data: %w", err)
+	}
+	w.buf = b
+	return nil
+}
+
+func Write(w io.Writer, a Array) error {
+	return a.writeNpy(&npyWriter{w: w, buf: make([]byte, 0, 64<<10+16)})
+}
+
+func Save(filename string, a Array) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filename, err)
+	}
+	bw := bufio.NewWriter(f)
+	if err := Write(bw, a); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,137 @@
+// npz archives: zip of name.npy members, stored by np.savez and deflated by np.savez_compressed
+
+package numgo
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Npz struct {
+	closer io.Closer
+	files  map[string]*zip.File
+}
+
+func NewNpz(r io.ReaderAt, size int64) (*Npz, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read npz: %w", err)
+	}
+	return newNpz(zr, nil), nil
+}
+
+func OpenNpz(filename string) (*Npz, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	return newNpz(&zr.Reader, zr), nil
+}
+
+func newNpz(zr *zip.Reader, closer io.Closer) *Npz {
+	z := &Npz{closer: closer, files: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		z.files[strings.TrimSuffix(f.Name, ".npy")] = f
+	}
+	return z
+}
+
+func (z *Npz) Close() error {
+	if z.closer == nil {
+		return nil
+	}
+	return z.closer.Close()
+}
+
+// array names without the .npy, sorted
+func (z *Npz) Names() []string {
+	return slices.Sorted(maps.Keys(z.files))
+}
+
+func (z *Npz) Get(name string) (Array, error) {
+	f, ok := z.files[name]
+	if !ok {
+		return nil, fmt.Errorf("no array %q in npz", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open npz member %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+	a, err := Read(bufio.NewReader(rc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read npz member %s: %w", f.Name, err)
+	}
+	return a, nil
+}
+
+func GetAs[T Elem](z *Npz, name string) (*NDArray[T], error) {
+	a, err := z.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return AsType[T](a)
+}
+
+// all arrays of an npz file
+func LoadNpz(filename string) (map[string]Array, error) {
+	z, err := OpenNpz(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	arrays := map[string]Array{}
+	for _, name := range z.Names() {
+		if arrays[name], err = z.Get(name); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+	}
+	return arrays, nil
+}
+
+// arrays as name.npy in name order, deflated if compress
+func WriteNpz(w io.Writer, arrays map[string]Array, compress bool) error {
+	zw := zip.NewWriter(w)
+	method := zip.Store
+	if compress {
+		method = zip.Deflate
+	}
+	nw := &npyWriter{buf: make([]byte, 0, 64<<10+16)}
+	for _, name := range slices.Sorted(maps.Keys(arrays)) {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name + ".npy", Method: method})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to npz: %w", name, err)
+		}
+		nw.w = fw
+		if err := arrays[name].writeNpy(nw); err != nil {
+			return fmt.Errorf("failed to add %s to npz: %w", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write npz: %w", err)
+	}
+	return nil
+}
+
+func SaveNpz(filename string, arrays map[string]Array, compress bool) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filename, err)
+	}
+	bw := bufio.NewWriter(f)
+	if err := WriteNpz(bw, arrays, compress); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return f.Close()
+}
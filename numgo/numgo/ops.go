@@ -0,0 +1,82 @@
+// elementwise operations with broadcasting
+
+package numgo
+
+// f of each element, a new C order array
+func Map[T, U Elem](a *NDArray[T], f func(T) U) *NDArray[U] {
+	out := New[U](a.shape...)
+	i := 0
+	for off := range a.offsets() {
+		out.data[i] = f(a.data[off])
+		i++
+	}
+	return out
+}
+
+// f of the elements of a and b broadcast together
+func Map2[T, U, V Elem](a *NDArray[T], b *NDArray[U], f func(T, U) V) (*NDArray[V], error) {
+	shape, err := BroadcastShapes(a.shape, b.shape)
+	if err != nil {
+		return nil, err
+	}
+	av, _ := a.BroadcastTo(shape...)
+	bv, _ := b.BroadcastTo(shape...)
+	out := New[V](shape...)
+	i := 0
+	walk(shape, []int{av.offset, bv.offset}, [][]int{av.strides, bv.strides}, func(offs []int) bool {
+		out.data[i] = f(av.data[offs[0]], bv.data[offs[1]])
+		i++
+		return true
+	})
+	return out, nil
+}
+
+func Add[T Number](a, b *NDArray[T]) (*NDArray[T], error) {
+	return Map2(a, b, func(x, y T) T { return x + y })
+}
+
+func Sub[T Number](a, b *NDArray[T]) (*NDArray[T], error) {
+	return Map2(a, b, func(x, y T) T { return x - y })
+}
+
+func Mul[T Number](a, b *NDArray[T]) (*NDArray[T], error) {
+	return Map2(a, b, func(x, y T) T { return x * y })
+}
+
+// integer division truncates like go and gives 0 for a zero divisor like numpy
+func Div[T Number](a, b *NDArray[T]) (*NDArray[T], error) {
+	kind := DTypeOf[T]().Kind
+	integer := kind == 'i' || kind == 'u'
+	return Map2(a, b, func(x, y T) T {
+		if integer && y == 0 {
+			return 0
+		}
+		return x / y
+	})
+}
+
+func Neg[T Number](a *NDArray[T]) *NDArray[T] {
+	return Map(a, func(x T) T { return -x })
+}
+
+// elementwise a == b, broadcast
+func Equal[T Elem](a, b *NDArray[T]) (*NDArray[bool], error) {
+	return Map2(a, b, func(x, y T) bool { return x == y })
+}
+
+// numpy allclose: same shape after broadcasting and |a-b| <= atol + rtol*|b|
+func AllClose[T Real](a, b *NDArray[T], rtol, atol float64) bool {
+	ok, err := Map2(a, b, func(x, y T) bool {
+		d := float64(x) - float64(y)
+		return max(d, -d) <= atol+rtol*max(float64(y), -float64(y))
+	})
+	if err != nil {
+		return false
+	}
+	for c := range ok.All() {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+// reductions along axes, all axes if none are given
+
+package numgo
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"slices"
+)
+
+// f of the elements along axes for each index of the other axes, which
+// keep their order in the result, i counts the reduced elements in C order
+func reduce[T, U Elem](a *NDArray[T], axes []int, f func(values iter.Seq2[int, T]) U) (*NDArray[U], error) {
+	n := len(a.shape)
+	reduced := make([]bool, n)
+	if len(axes) == 0 {
+		for i := range reduced {
+			reduced[i] = true
+		}
+	}
+	for _, axis := range axes {
+		axis, err := axisOf(axis, n)
+		if err != nil {
+			return nil, err
+		}
+		if reduced[axis] {
+			return nil, fmt.Errorf("duplicate axis %d in reduction", axis)
+		}
+		reduced[axis] = true
+	}
+	var keep, inner []int
+	for i, r := range reduced {
+		if r {
+			inner = append(inner, i)
+		} else {
+			keep = append(keep, i)
+		}
+	}
+
+	v, _ := a.Transpose(slices.Concat(keep, inner)...)
+	outer, innerShape := v.shape[:len(keep)], v.shape[len(keep):]
+	outerStrides, innerStrides := v.strides[:len(keep)], v.strides[len(keep):]
+	out := New[U](outer...)
+	j := 0
+	walk(outer, []int{v.offset}, [][]int{outerStrides}, func(base []int) bool {
+		out.data[j] = f(func(yield func(int, T) bool) {
+			i := 0
+			walk(innerShape, []int{base[0]}, [][]int{innerStrides}, func(offs []int) bool {
+				ok := yield(i, v.data[offs[0]])
+				i++
+				return ok
+			})
+		})
+		j++
+		return true
+	})
+	return out, nil
+}
+
+// sum in U, each element converted
+func sumIn[U, T Real](a *NDArray[T], axes []int) (*NDArray[U], error) {
+	return reduce(a, axes, func(values iter.Seq2[int, T]) U {
+		var sum U
+		for _, v := range values {
+			sum += U(v)
+		}
+		return sum
+	})
+}
+
+// numpy's sum: signed ints sum in int64 and unsigned in uint64 so small
+// types don't wrap, floats and complex in their own type
+func Sum[T Number](a *NDArray[T], axes ...int) (Array, error) {
+	switch a := any(a).(type) {
+	case *NDArray[int8]:
+		return sumIn[int64](a, axes)
+	case *NDArray[int16]:
+		return sumIn[int64](a, axes)
+	case *NDArray[int32]:
+		return sumIn[int64](a, axes)
+	case *NDArray[uint8]:
+		return sumIn[uint64](a, axes)
+	case *NDArray[uint16]:
+		return sumIn[uint64](a, axes)
+	case *NDArray[uint32]:
+		return sumIn[uint64](a, axes)
+	}
+	return reduce(a, axes, func(values iter.Seq2[int, T]) T {
+		var sum T
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	})
+}
+
+// Sum converted to U
+func SumAs[U Elem, T Number](a *NDArray[T], axes ...int) (*NDArray[U], error) {
+	s, err := Sum(a, axes...)
+	if err != nil {
+		return nil, err
+	}
+	return AsType[U](s)
+}
+
+// float64 mean, nan over no elements
+func Mean[T Real](a *NDArray[T], axes ...int) (*NDArray[float64], error) {
+	return reduce(a, axes, func(values iter.Seq2[int, T]) float64 {
+		sum, n := 0.0, 0
+		for _, v := range values {
+			sum += float64(v)
+			n++
+		}
+		if n == 0 {
+			return math.NaN()
+		}
+		return sum / float64(n)
+	})
+}
+
+// index along the reduced axes (in C order over them) of the first
+// element that better is true for, nan first like numpy
+func arg[T Real](name string, a *NDArray[T], axes []int, better func(x, y T) bool) (*NDArray[int64], error) {
+	if a.Size() == 0 {
+		return nil, fmt.Errorf("attempt to get %s of an empty array", name)
+	}
+	return reduce(a, axes, func(values iter.Seq2[int, T]) int64 {
+		best, bi := T(0), -1
+		for i, v := range values {
+			if v != v { // nan
+				return int64(i)
+			}
+			if bi < 0 || better(v, best) {
+				best, bi = v, i
+			}
+		}
+		return int64(bi)
+	})
+}
+
+func ArgMax[T Real](a *NDArray[T], axes ...int) (*NDArray[int64], error) {
+	return arg("argmax", a, axes, func(x, y T) bool { return x > y })
+}
+
+func ArgMin[T Real](a *NDArray[T], axes ...int) (*NDArray[int64], error) {
+	return arg("argmin", a, axes, func(x, y T) bool { return x < y })
+}
+
+// the element at the ArgMax or ArgMin indices
+func pick[T Real](a *NDArray[T], axes []int, better func(x, y T) bool) (*NDArray[T], error) {
+	if a.Size() == 0 {
+		return nil, fmt.Errorf("zero-size array to reduction operation which has no identity")
+	}
+	return reduce(a, axes, func(values iter.Seq2[int, T]) T {
+		best, first := T(0), true
+		for _, v := range values {
+			if v != v {
+				return v
+			}
+			if first || better(v, best) {
+				best, first = v, false
+			}
+		}
+		return best
+	})
+}
+
+// nan if any element along the axes is nan
+func Max[T Real](a *NDArray[T], axes ...int) (*NDArray[T], error) {
+	return pick(a, axes, func(x, y T) bool { return x > y })
+}
+
+func Min[T Real](a *NDArray[T], axes ...int) (*NDArray[T], error) {
+	return pick(a, axes, func(x, y T) bool { return x < y })
+}
@@ -0,0 +1,315 @@
+// views: numpy basic indexing, reshape, transpose and broadcast, no copies
+
+package numgo
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// omitted start or stop of a Span, python's None
+const None = math.MinInt
+
+type indexKind int
+
+const (
+	indexAt indexKind = iota
+	indexSpan
+	indexNewAxis
+	indexEllipsis
+)
+
+// one item of a numpy index: i, start:stop:step, np.newaxis or ...
+type Index struct {
+	kind              indexKind
+	start, stop, step int
+}
+
+var (
+	All      = Span(None, None, 1)
+	NewAxis  = Index{kind: indexNewAxis}
+	Ellipsis = Index{kind: indexEllipsis}
+)
+
+// integer index, negative from the end, drops the axis
+func At(i int) Index {
+	return Index{kind: indexAt, start: i}
+}
+
+// start:stop:step, None for omitted bounds, step 0 is 1
+func Span(start, stop, step int) Index {
+	if step == 0 {
+		step = 1
+	}
+	return Index{kind: indexSpan, start: start, stop: stop, step: step}
+}
+
+// numpy index expression like "1, ::-1, ..., None, -2:"
+func ParseIndex(expr string) ([]Index, error) {
+	var idx []Index
+	if strings.TrimSpace(expr) == "" {
+		return idx, nil
+	}
+	for item := range strings.SplitSeq(expr, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "...":
+			idx = append(idx, Ellipsis)
+		case item == "None" || item == "newaxis":
+			idx = append(idx, NewAxis)
+		case strings.Contains(item, ":"):
+			parts := strings.Split(item, ":")
+			if len(parts) > 3 {
+				return nil, fmt.Errorf("invalid slice %q", item)
+			}
+			bounds := []int{None, None, 1}
+			for i, p := range parts {
+				if p = strings.TrimSpace(p); p == "" {
+					continue
+				}
+				n, err := strconv.Atoi(p)
+				if err != nil || i == 2 && n == 0 {
+					return nil, fmt.Errorf("invalid slice %q", item)
+				}
+				bounds[i] = n
+			}
+			idx = append(idx, Span(bounds[0], bounds[1], bounds[2]))
+		default:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q", item)
+			}
+			idx = append(idx, At(n))
+		}
+	}
+	return idx, nil
+}
+
+// python's slice.indices: first index and length of the span over n
+func (ix Index) indices(n int) (start, length int) {
+	start, stop, step := ix.start, ix.stop, ix.step
+	lo, hi := 0, n // clamp range of start and stop
+	if step < 0 {
+		lo, hi = -1, n-1
+	}
+	bound := func(i, def int) int {
+		if i == None {
+			return def
+		}
+		if i < 0 {
+			i += n
+		}
+		return min(max(i, lo), hi)
+	}
+	if step > 0 {
+		start, stop = bound(start, 0), bound(stop, n)
+		return start, max(0, (stop-start+step-1)/step)
+	}
+	start, stop = bound(start, n-1), bound(stop, -1)
+	return start, max(0, (start-stop-step-1)/-step)
+}
+
+// view of a numpy basic index, a[1, ::-1, ..., np.newaxis]
+func (a *NDArray[T]) Slice(idx ...Index) (*NDArray[T], error) {
+	consumed, ellipses := 0, 0
+	for _, ix := range idx {
+		switch ix.kind {
+		case indexAt, indexSpan:
+			consumed++
+		case indexEllipsis:
+			ellipses++
+		}
+	}
+	if ellipses > 1 {
+		return nil, fmt.Errorf("an index can only have a single ellipsis")
+	}
+	if consumed > len(a.shape) {
+		return nil, fmt.Errorf("too many indices for an array of %d dimensions", len(a.shape))
+	}
+	if ellipses == 0 {
+		idx = append(slices.Clone(idx), Ellipsis) // the missing trailing axes
+	}
+
+	v := *a
+	v.shape, v.strides = nil, nil
+	axis := 0
+	for _, ix := range idx {
+		switch ix.kind {
+		case indexAt:
+			n, i := a.shape[axis], ix.start
+			if i < 0 {
+				i += n
+			}
+			if i < 0 || i >= n {
+				return nil, fmt.Errorf("index %d is out of bounds for axis %d with size %d", ix.start, axis, n)
+			}
+			v.offset += i * a.strides[axis]
+			axis++
+		case indexSpan:
+			start, length := ix.indices(a.shape[axis])
+			if length > 0 {
+				v.offset += start * a.strides[axis]
+			}
+			v.shape = append(v.shape, length)
+			v.strides = append(v.strides, a.strides[axis]*ix.step)
+			axis++
+		case indexNewAxis:
+			v.shape = append(v.shape, 1)
+			v.strides = append(v.strides, 0)
+		case indexEllipsis:
+			rest := len(a.shape) - consumed
+			v.shape = append(v.shape, a.shape[axis:axis+rest]...)
+			v.strides = append(v.strides, a.strides[axis:axis+rest]...)
+			axis += rest
+		}
+	}
+	if v.shape == nil {
+		v.shape, v.strides = []int{}, []int{}
+	}
+	return &v, nil
+}
+
+// Slice of a parsed index expression, a.SliceExpr("1, ::-1, ..., None")
+func (a *NDArray[T]) SliceExpr(expr string) (*NDArray[T], error) {
+	idx, err := ParseIndex(expr)
+	if err != nil {
+		return nil, err
+	}
+	return a.Slice(idx...)
+}
+
+// normalized axis, negative from the end
+func axisOf(axis, ndim int) (int, error) {
+	if axis < -ndim || axis >= ndim {
+		return 0, fmt.Errorf("axis %d is out of bounds for an array of %d dimensions", axis, ndim)
+	}
+	if axis < 0 {
+		axis += ndim
+	}
+	return axis, nil
+}
+
+// view with permuted axes, reversed without axes
+func (a *NDArray[T]) Transpose(axes ...int) (*NDArray[T], error) {
+	n := len(a.shape)
+	if len(axes) == 0 {
+		for i := n - 1; i >= 0; i-- {
+			axes = append(axes, i)
+		}
+	}
+	if len(axes) != n {
+		return nil, fmt.Errorf("axes %v don't match an array of %d dimensions", axes, n)
+	}
+	v := *a
+	v.shape, v.strides = make([]int, n), make([]int, n)
+	seen := make([]bool, n)
+	for i, axis := range axes {
+		axis, err := axisOf(axis, n)
+		if err != nil {
+			return nil, err
+		}
+		if seen[axis] {
+			return nil, fmt.Errorf("repeated axis %d in transpose", axis)
+		}
+		seen[axis] = true
+		v.shape[i], v.strides[i] = a.shape[axis], a.strides[axis]
+	}
+	return &v, nil
+}
+
+// reversed axes
+func (a *NDArray[T]) T() *NDArray[T] {
+	v, _ := a.Transpose()
+	return v
+}
+
+// C order copy, contiguous
+func (a *NDArray[T]) Copy() *NDArray[T] {
+	c, _ := FromSlice(a.Values(), a.shape...)
+	c.order = a.order
+	return c
+}
+
+// same elements in shape, one dimension may be -1 to infer it; a view
+// of contiguous arrays, a copy of others
+func (a *NDArray[T]) Reshape(shape ...int) (*NDArray[T], error) {
+	shape = slices.Clone(shape)
+	infer, known := -1, 1
+	for i, d := range shape {
+		switch {
+		case d == -1 && infer < 0:
+			infer = i
+		case d < 0:
+			return nil, fmt.Errorf("invalid shape %v", shape)
+		default:
+			known *= d
+		}
+	}
+	if infer >= 0 && known > 0 {
+		shape[infer] = a.Size() / known
+	}
+	if product(shape) != a.Size() || infer >= 0 && known == 0 {
+		return nil, fmt.Errorf("cannot reshape array of size %d into shape %v", a.Size(), shape)
+	}
+	src := a
+	if !a.IsContiguous() {
+		src = a.Copy()
+	}
+	v := *src
+	v.shape, v.strides = shape, cStrides(shape)
+	return &v, nil
+}
+
+// 1-d view or copy
+func (a *NDArray[T]) Ravel() *NDArray[T] {
+	v, _ := a.Reshape(-1)
+	return v
+}
+
+// numpy broadcasting: shapes aligned right, dimensions equal or 1
+func BroadcastShapes(shapes ...[]int) ([]int, error) {
+	n := 0
+	for _, s := range shapes {
+		n = max(n, len(s))
+	}
+	out := make([]int, n)
+	for i := range out {
+		out[i] = 1
+	}
+	for _, s := range shapes {
+		for i, d := range s {
+			j := n - len(s) + i
+			switch {
+			case d == out[j] || d == 1:
+			case out[j] == 1:
+				out[j] = d
+			default:
+				return nil, fmt.Errorf("shapes %v cannot be broadcast together", shapes)
+			}
+		}
+	}
+	return out, nil
+}
+
+// read-only view repeating a along the new and size 1 axes of shape with stride 0
+func (a *NDArray[T]) BroadcastTo(shape ...int) (*NDArray[T], error) {
+	if len(shape) < len(a.shape) {
+		return nil, fmt.Errorf("cannot broadcast shape %v to %v", a.shape, shape)
+	}
+	v := *a
+	v.shape, v.strides = slices.Clone(shape), make([]int, len(shape))
+	lead := len(shape) - len(a.shape)
+	for i, d := range a.shape {
+		switch {
+		case d == shape[lead+i]:
+			v.strides[lead+i] = a.strides[i]
+		case d == 1:
+		default:
+			return nil, fmt.Errorf("cannot broadcast shape %v to %v", a.shape, shape)
+		}
+	}
+	return &v, nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"numgo/numgo"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+func check(ok bool, format string, args ...any) {
+	if !ok {
+		log.Fatalf("FAIL: "+format, args...)
+	}
+}
+
+// write a, read it back as T and compare
+func roundTrip[T numgo.Elem](a *numgo.NDArray[T]) *numgo.NDArray[T] {
+	var b bytes.Buffer
+	if err := numgo.Write(&b, a); err != nil {
+		log.Fatal(err)
+	}
+	r, err := numgo.ReadAs[T](&b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	check(r.DType() == a.DType(), "dtype %v != %v", r.DType(), a.DType())
+	check(slices.Equal(r.Shape(), a.Shape()), "shape %v != %v", r.Shape(), a.Shape())
+	check(slices.Equal(r.Values(), a.Values()), "%v values differ", a)
+	return r
+}
+
+func TestNpy() {
+	a, err := numgo.Load("a.npy")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("a.npy: %v %s\n", a, a.DType())
+	af := a.(*numgo.NDArray[float64])
+	for i, v := range af.Values() {
+		check(v == float64(i), "a[%d] = %v", i, v)
+	}
+	check(af.At(4, 3, 2, 2) == 179 && af.At(1, 2, 0, 1) == 1*36+2*9+0*3+1, "a.At")
+
+	ab, err := numgo.LoadAs[float64]("ab.npy")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("ab.npy: %v\n", ab)
+	check(slices.Equal(ab.Shape(), []int{10, 20, 10, 20}), "ab shape %v", ab.Shape())
+
+	// file round trip, same values
+	dir, err := os.MkdirTemp("", "numgo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.npy", "ab.npy"} {
+		src, _ := numgo.LoadAs[float64](name)
+		out := filepath.Join(dir, name)
+		if err := numgo.Save(out, src); err != nil {
+			log.Fatal(err)
+		}
+		back, err := numgo.LoadAs[float64](out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		check(slices.Equal(back.Values(), src.Values()), "%s round trip", name)
+		// our own files round trip byte for byte
+		again := filepath.Join(dir, "again.npy")
+		numgo.Save(again, back)
+		b1, _ := os.ReadFile(out)
+		b2, _ := os.ReadFile(again)
+		check(bytes.Equal(b1, b2), "%s not byte identical", name)
+	}
+
+	// every dtype
+	roundTrip(must(numgo.FromSlice([]bool{true, false, true, true, false, false}, 2, 3)))
+	roundTrip(must(numgo.FromSlice([]int8{-128, -1, 0, 1, 127}, 5)))
+	roundTrip(must(numgo.FromSlice([]int16{-32768, -1, 0, 1, 32767}, 5)))
+	roundTrip(must(numgo.FromSlice([]int32{math.MinInt32, -1, 0, 1, math.MaxInt32}, 5)))
+	roundTrip(must(numgo.FromSlice([]int64{math.MinInt64, -1, 0, 1, math.MaxInt64}, 5)))
+	roundTrip(must(numgo.FromSlice([]uint8{0, 1, 255}, 3)))
+	roundTrip(must(numgo.FromSlice([]uint16{0, 1, 65535}, 3)))
+	roundTrip(must(numgo.FromSlice([]uint32{0, 1, math.MaxUint32}, 3)))
+	roundTrip(must(numgo.FromSlice([]uint64{0, 1, math.MaxUint64}, 3)))
+	roundTrip(must(numgo.FromSlice([]numgo.Float16{numgo.NewFloat16(1.5), numgo.NewFloat16(-65504), numgo.NewFloat16(6e-8)}, 3)))
+	roundTrip(must(numgo.FromSlice([]float32{1.5, float32(math.Inf(-1)), math.SmallestNonzeroFloat32}, 3)))
+	roundTrip(must(numgo.FromSlice([]float64{math.Pi, -0.0, math.MaxFloat64}, 3)))
+	roundTrip(must(numgo.FromSlice([]complex64{1 + 2i, -3i}, 2)))
+	roundTrip(must(numgo.FromSlice([]complex128{1 + 2i, -3i, complex(math.Pi, math.E)}, 1, 3)))
+	roundTrip(numgo.New[float64]())      // 0-d
+	roundTrip(numgo.New[int32](0, 3))    // empty
+	roundTrip(numgo.New[uint8](2, 0, 4)) // empty
+
+	// float16 conversions
+	for f, bits := range map[float32]uint16{1: 0x3c00, -2: 0xc000, 65504: 0x7bff, 65520: 0x7c00, 6e-8: 0x0001, 0.333333: 0x3555, 1e-9: 0} {
+		h := numgo.NewFloat16(f)
+		check(uint16(h) == bits, "float16(%v) = %#x, want %#x", f, uint16(h), bits)
+	}
+	check(numgo.Float16(0x0001).Float32() == float32(math.Ldexp(1, -24)), "smallest float16")
+	check(math.IsNaN(float64(numgo.NewFloat16(float32(math.NaN())).Float32())), "float16 nan")
+
+	// big endian, fortran order, written back as read
+	be := append([]byte("\x93NUMPY\x01\x00"), 0, 0)
+	hdr := "{'descr': '>i4', 'fortran_order': True, 'shape': (2, 3), }"
+	hdr += string(bytes.Repeat([]byte(" "), 64-(10+len(hdr)+1)%64)) + "\n"
+	binary.LittleEndian.PutUint16(be[8:], uint16(len(hdr)))
+	be = append(be, hdr...)
+	for _, v := range []int32{0, 3, 1, 4, 2, 5} { // column major 0..5
+		be = binary.BigEndian.AppendUint32(be, uint32(v))
+	}
+	fa, err := numgo.ReadAs[int32](bytes.NewReader(be))
+	if err != nil {
+		log.Fatal(err)
+	}
+	check(slices.Equal(fa.Values(), []int32{0, 1, 2, 3, 4, 5}) && fa.IsFortran() && fa.DType().String() == ">i4", "big endian fortran: %v %v", fa.Values(), fa.DType())
+	var out bytes.Buffer
+	numgo.Write(&out, fa)
+	check(bytes.Equal(out.Bytes()[len(out.Bytes())-24:], be[len(be)-24:]), "big endian fortran data not written back as read")
+	roundTrip(fa)
+
+	// version 2 and 3 headers
+	for _, v := range []byte{2, 3} {
+		hdr := "{'descr': '<u2', 'fortran_order': False, 'shape': (3,), }\n"
+		b := append([]byte("\x93NUMPY"), v, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(hdr)))
+		b = append(b, hdr...)
+		b = append(b, 1, 0, 2, 0, 3, 0)
+		r, err := numgo.ReadAs[uint16](bytes.NewReader(b))
+		if err != nil {
+			log.Fatal(err)
+		}
+		check(slices.Equal(r.Values(), []uint16{1, 2, 3}), "version %d values", v)
+	}
+
+	// bad headers error instead of allocating or overflowing
+	for _, shape := range []string{"(281474976710656,)", "(4611686018427387904, 4)", "(3, -1)", "(2, 3)"} {
+		hdr := "{'descr': '<f8', 'fortran_order': False, 'shape': " + shape + ", }\n"
+		b := append([]byte("\x93NUMPY\x01\x00"), byte(len(hdr)), 0)
+		b = append(b, hdr...)
+		b = append(b, make([]byte, 16)...) // 2 of the elements
+		_, err := numgo.Read(bytes.NewReader(b))
+		check(err != nil, "shape %s accepted", shape)
+		fmt.Println(err)
+	}
+
+	// conversions
+	i8 := must(numgo.AsType[int8](af))
+	check(i8.At(4, 3, 2, 2) == int8(-77) && i8.At(0, 0, 0, 1) == 1, "astype int8 %v", i8.At(4, 3, 2, 2))
+	c := must(numgo.AsType[complex64](af))
+	check(c.At(0, 0, 1, 0) == 3, "astype complex64")
+	_, err = numgo.AsType[float64](c)
+	check(err != nil, "complex to float64 should fail")
+	h := must(numgo.AsType[numgo.Float16](af))
+	check(h.At(4, 3, 2, 2).Float32() == 179, "astype float16")
+
+	fmt.Println("npy ok")
+}
+
+func TestNpz() {
+	dir, err := os.MkdirTemp("", "numgo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, _ := numgo.Load("a.npy")
+	ab, _ := numgo.Load("ab.npy")
+	flags := must(numgo.FromSlice([]bool{true, false}, 2))
+	arrays := map[string]numgo.Array{"a": a, "ab": ab, "flags": flags}
+	for _, compress := range []bool{false, true} {
+		name := filepath.Join(dir, fmt.Sprintf("ab%v.npz", compress))
+		if err := numgo.SaveNpz(name, arrays, compress); err != nil {
+			log.Fatal(err)
+		}
+		st, _ := os.Stat(name)
+		z, err := numgo.OpenNpz(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		check(slices.Equal(z.Names(), []string{"a", "ab", "flags"}), "npz names %v", z.Names())
+		back, err := numgo.GetAs[float64](z, "ab")
+		if err != nil {
+			log.Fatal(err)
+		}
+		check(slices.Equal(back.Values(), ab.(*numgo.NDArray[float64]).Values()), "npz ab")
+		f, err := z.Get("flags")
+		if err != nil {
+			log.Fatal(err)
+		}
+		check(slices.Equal(f.(*numgo.NDArray[bool]).Values(), []bool{true, false}), "npz flags")
+		_, err = z.Get("b")
+		check(err != nil, "missing npz member")
+		z.Close()
+
+		all, err := numgo.LoadNpz(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %d bytes, %d arrays, a %v\n", filepath.Base(name), st.Size(), len(all), all["a"])
+	}
+	fmt.Println("npz ok")
+}
+
+func near(x, y float64) bool {
+	return math.Abs(x-y) <= 1e-9*max(1, math.Abs(y))
+}
+
+func TestNDArray() {
+	a := must(numgo.LoadAs[float64]("a.npy")) // arange(180).reshape(5, 4, 3, 3)
+	check(slices.Equal(a.Strides(), []int{36, 9, 3, 1}), "a strides %v", a.Strides())
+	r := must(numgo.Arange[float64](180).Reshape(5, 4, -1, 3))
+	check(slices.Equal(r.Shape(), a.Shape()) && numgo.AllClose(r, a, 0, 0), "arange reshape %v", r.Shape())
+
+	// slicing views share the data
+	s := must(a.SliceExpr("1, ::-1, 1:, None, -1"))
+	check(slices.Equal(s.Shape(), []int{4, 2, 1}) && s.At(0, 0, 0) == 36+27+3+2 && s.At(3, 1, 0) == 36+6+2, "a[1, ::-1, 1:, None, -1] %v %v", s.Shape(), s.Values())
+	e := must(a.Slice(numgo.Ellipsis, numgo.At(0)))
+	check(slices.Equal(e.Shape(), []int{5, 4, 3}) && e.At(4, 3, 2) == 177, "a[..., 0]")
+	st := must(a.Slice(numgo.Span(numgo.None, numgo.None, 2), numgo.All, numgo.Span(-1, numgo.None, -2)))
+	check(slices.Equal(st.Shape(), []int{3, 4, 2, 3}) && st.At(2, 1, 1, 0) == 144+9+0 && st.At(0, 0, 0, 1) == 6+1, "a[::2, :, -1::-2] %v", st.Shape())
+	empty := must(a.SliceExpr("3:1"))
+	check(slices.Equal(empty.Shape(), []int{0, 4, 3, 3}) && empty.Size() == 0, "a[3:1]")
+	_, err := a.SliceExpr("5")
+	check(err != nil, "a[5] should fail")
+	_, err = a.SliceExpr("..., 0, ...")
+	check(err != nil, "two ellipses should fail")
+	c := a.Copy()
+	v := must(c.SliceExpr("2, 1"))
+	v.Set(-1, 0, 0)
+	check(c.At(2, 1, 0, 0) == -1 && a.At(2, 1, 0, 0) == 81, "views don't write through")
+
+	// transpose is a view, reshape of it a copy
+	t := a.T()
+	check(slices.Equal(t.Shape(), []int{3, 3, 4, 5}) && slices.Equal(t.Strides(), []int{1, 3, 9, 36}) && t.IsFortran(), "a.T")
+	check(t.At(2, 1, 3, 4) == a.At(4, 3, 1, 2), "a.T element")
+	p := must(a.Transpose(0, 2, 1, -1))
+	flat := p.Ravel()
+	check(flat.At(3) == 9 && flat.At(12) == 1*3, "transpose ravel %v", flat.Values()[:13])
+	_, err = a.Transpose(0, 0, 1, 2)
+	check(err != nil, "repeated transpose axis")
+	_, err = a.Reshape(7, -1)
+	check(err != nil, "reshape 180 to (7, -1)")
+
+	// broadcasting
+	sum := must(numgo.Add(a, must(a.SliceExpr("0")))) // (5,4,3,3) + (4,3,3)
+	check(sum.At(4, 3, 2, 2) == 179+35, "broadcast add")
+	col := must(numgo.Arange[float64](3).Reshape(3, 1))
+	outer := must(numgo.Mul(col, numgo.Arange[float64](4))) // (3,1) * (4,)
+	check(slices.Equal(outer.Shape(), []int{3, 4}) && outer.At(2, 3) == 6, "outer product")
+	half := must(numgo.Div(a, numgo.Scalar(2.0)))
+	check(half.At(0, 0, 0, 3-2) == 0.5, "scalar div")
+	_, err = numgo.Sub(a, numgo.Arange[float64](4))
+	check(err != nil, "(5,4,3,3) - (4,) should fail")
+	iz := must(numgo.Div(numgo.Arange[int32](3), numgo.Scalar[int32](0)))
+	check(slices.Equal(iz.Values(), []int32{0, 0, 0}), "integer division by zero")
+	zero := must(numgo.Sub(a, a.Copy()))
+	check(must(numgo.Max(zero)).Item() == 0, "a - a")
+
+	// reductions of a
+	check(must(numgo.SumAs[float64](a)).Item() == 179*180/2, "sum a")
+	s0 := must(numgo.SumAs[float64](a, 0))
+	check(slices.Equal(s0.Shape(), []int{4, 3, 3}) && s0.At(1, 2, 0) == 360+5*(9+6), "sum axis 0")
+	m := must(numgo.Mean(a, 1, 2, 3))
+	check(slices.Equal(m.Values(), []float64{17.5, 53.5, 89.5, 125.5, 161.5}), "mean axes 1,2,3 %v", m.Values())
+	mx := must(numgo.Max(a, -1))
+	check(slices.Equal(mx.Shape(), []int{5, 4, 3}) && mx.At(1, 1, 1) == 36+9+3+2, "max axis -1")
+	check(must(numgo.Min(a, 0, 3)).At(3, 2) == 27+6, "min axes 0,3")
+	check(must(numgo.ArgMax(a)).Item() == 179 && must(numgo.ArgMin(t, 3)).At(0, 0, 0) == 0, "argmax a")
+	check(must(numgo.ArgMax(must(a.SliceExpr("::-1")), 0)).At(0, 0, 0) == 0, "argmax reversed")
+	_, err = numgo.SumAs[float64](a, 4)
+	check(err != nil, "axis 4 of 4-d")
+	u8 := must(numgo.Sum(numgo.Arange[uint8](200))).(*numgo.NDArray[uint64])
+	check(u8.Item() == 19900, "uint8 sum %v", u8.Item())
+	i8 := must(numgo.Sum(must(numgo.Sub(numgo.Arange[int8](100), numgo.Scalar[int8](100))))).(*numgo.NDArray[int64])
+	check(i8.Item() == -5050, "int8 sum %v", i8.Item())
+	_, ok := must(numgo.Sum(numgo.Arange[float32](3))).(*numgo.NDArray[float32])
+	check(ok, "float32 sum dtype")
+
+	// ab: its (i, :, i, :) blocks are identity
+	ab := must(numgo.LoadAs[float64]("ab.npy"))
+	eye := must(numgo.FromSlice(make([]float64, 400), 20, 20))
+	trace := 0.0
+	for j := range 20 {
+		eye.Set(1, j, j)
+	}
+	for i := range 10 {
+		block := must(ab.Slice(numgo.At(i), numgo.All, numgo.At(i)))
+		check(numgo.AllClose(block, eye, 0, 1e-10), "ab[%d, :, %d, :] is not the identity", i, i)
+		trace += must(numgo.SumAs[float64](must(numgo.Mul(block, eye)))).Item()
+	}
+	check(near(trace, 200), "ab trace %v", trace)
+	check(near(must(numgo.SumAs[float64](ab)).Item(), 122.14536399221763), "ab sum")
+	check(must(numgo.Max(ab)).Item() == 752.0143150773424 && must(numgo.ArgMax(ab)).Item() == 16569, "ab max")
+	check(must(numgo.Min(ab)).Item() == -755.8409290396023 && must(numgo.ArgMin(ab)).Item() == 38969, "ab min")
+	m01 := must(numgo.Max(ab, 0, 1))
+	check(slices.Equal(m01.Shape(), []int{10, 20}) && m01.At(0, 2) == 3.1282845167581605 && must(numgo.ArgMax(m01)).Item() == 169, "ab max axes 0,1")
+	check(near(must(numgo.SumAs[float64](ab, 3)).At(9, 19, 8), -99.01602295915565), "ab sum axis 3")
+	check(near(must(numgo.Mean(ab, 1)).At(4, 8, 0), -18.410687881754352), "ab mean axis 1")
+	check(must(numgo.ArgMax(ab, 2)).At(0, 0, 0) == 8, "ab argmax axis 2")
+	ab2 := must(ab.Reshape(200, 200))
+	check(near(must(numgo.SumAs[float64](ab2.T(), 1)).At(0), must(numgo.SumAs[float64](ab2, 0)).At(0)), "ab sums of the transpose")
+	abT := must(ab.Transpose(2, 3, 0, 1))
+	check(abT.At(1, 0, 0, 0) == ab.At(0, 0, 1, 0) && abT.At(1, 0, 0, 0) == 4.825280133038967, "ab transpose")
+
+	// views written in C order
+	var b bytes.Buffer
+	numgo.Write(&b, s)
+	back := must(numgo.ReadAs[float64](&b))
+	check(slices.Equal(back.Values(), s.Values()) && back.IsContiguous(), "write a view")
+
+	fmt.Println("ndarray ok")
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+func main() {
+	TestNpy()
+	TestNpz()
+	TestNDArray()
+}